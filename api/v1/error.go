@@ -33,3 +33,357 @@ func (e ErrOffsetOutOfRange) Code() codes.Code {
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+type ErrOutOfOrderSequence struct {
+	ProducerId string
+	Expected   uint64
+	Actual     uint64
+}
+
+func (e ErrOutOfOrderSequence) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.FailedPrecondition,
+		fmt.Sprintf("out of order sequence: producer=%s, expected=%d, actual=%d", e.ProducerId, e.Expected, e.Actual),
+	)
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: fmt.Sprintf("The sequence of producer %s must be %d: %d", e.ProducerId, e.Expected, e.Actual),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrOutOfOrderSequence) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrOutOfOrderSequence) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrTransactionNotFound struct {
+	TransactionId string
+}
+
+func (e ErrTransactionNotFound) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf("transaction not found: %s", e.TransactionId))
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: fmt.Sprintf("The transaction is not in progress: %s", e.TransactionId),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrTransactionNotFound) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrTransactionNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// read_committed で読めないレコード（アボート済み、制御レコード）
+type ErrRecordFiltered struct {
+	Offset uint64
+}
+
+func (e ErrRecordFiltered) GRPCStatus() *status.Status {
+	st := status.New(codes.NotFound, fmt.Sprintf("record filtered: %d", e.Offset))
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: fmt.Sprintf("The requested record is aborted or a control record: %d", e.Offset),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrRecordFiltered) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrRecordFiltered) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// キーを持つレコードが存在しない、またはキーを索引していない
+type ErrKeyNotFound struct {
+	Key []byte
+}
+
+func (e ErrKeyNotFound) GRPCStatus() *status.Status {
+	st := status.New(codes.NotFound, fmt.Sprintf("key not found: %q", e.Key))
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: fmt.Sprintf("No record was found for the key: %q", e.Key),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrKeyNotFound) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrKeyNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ログの設定でキー索引が有効になっていない
+type ErrKeyIndexDisabled struct{}
+
+func (e ErrKeyIndexDisabled) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, "key index is not enabled")
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: "The log must be configured with the key index to read records by key",
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrKeyIndexDisabled) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrKeyIndexDisabled) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// サーバのログが保持していないトピック
+type ErrTopicNotFound struct {
+	Topic string
+}
+
+func (e ErrTopicNotFound) GRPCStatus() *status.Status {
+	st := status.New(codes.NotFound, fmt.Sprintf("topic not found: %s", e.Topic))
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: fmt.Sprintf("The server does not hold the topic: %s", e.Topic),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrTopicNotFound) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrTopicNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// 有効期限を過ぎたレコード
+type ErrRecordExpired struct {
+	Offset uint64
+}
+
+func (e ErrRecordExpired) GRPCStatus() *status.Status {
+	st := status.New(codes.NotFound, fmt.Sprintf("record expired: %d", e.Offset))
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: fmt.Sprintf("The requested record has expired: %d", e.Offset),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrRecordExpired) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrRecordExpired) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ディスクの空き容量不足やログの容量上限超過により追記できない
+type ErrStorageExhausted struct {
+	Reason string
+}
+
+func (e ErrStorageExhausted) GRPCStatus() *status.Status {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("storage exhausted: %s", e.Reason))
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: fmt.Sprintf("The record cannot be appended: %s", e.Reason),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrStorageExhausted) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrStorageExhausted) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrRecordTooLarge struct {
+	Size uint64
+	Max  uint64
+}
+
+func (e ErrRecordTooLarge) GRPCStatus() *status.Status {
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("record too large: %d bytes (max %d bytes)", e.Size, e.Max))
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: fmt.Sprintf("The record must be at most %d bytes: %d", e.Max, e.Size),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrRecordTooLarge) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrRecordTooLarge) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrSchemaNotFound struct {
+	Topic   string
+	Version uint32
+}
+
+func (e ErrSchemaNotFound) GRPCStatus() *status.Status {
+	st := status.New(codes.NotFound, fmt.Sprintf("schema not found: topic=%s, version=%d", e.Topic, e.Version))
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: fmt.Sprintf("The schema of topic %s is not registered: version %d", e.Topic, e.Version),
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrSchemaNotFound) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrSchemaNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrIncompatibleSchema struct {
+	Topic   string
+	Reasons []string
+}
+
+func (e ErrIncompatibleSchema) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf("incompatible schema: topic=%s, %v", e.Topic, e.Reasons))
+	d := &errdetails.PreconditionFailure{}
+	for _, reason := range e.Reasons {
+		d.Violations = append(d.Violations, &errdetails.PreconditionFailure_Violation{
+			Type:        "COMPATIBILITY",
+			Subject:     e.Topic,
+			Description: reason,
+		})
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrIncompatibleSchema) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrIncompatibleSchema) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// レコードの値がスキーマに適合しない
+type ErrInvalidRecordValue struct {
+	Topic      string
+	Version    uint32
+	Violations []string
+}
+
+func (e ErrInvalidRecordValue) GRPCStatus() *status.Status {
+	st := status.New(
+		codes.InvalidArgument,
+		fmt.Sprintf("record value does not match schema: topic=%s, version=%d", e.Topic, e.Version),
+	)
+	d := &errdetails.BadRequest{}
+	for _, v := range e.Violations {
+		d.FieldViolations = append(d.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "record.value",
+			Description: v,
+		})
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrInvalidRecordValue) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrInvalidRecordValue) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// クライアントが送ってはいけない内容を含むレコード
+type ErrInvalidRecord struct {
+	Field  string
+	Reason string
+}
+
+func (e ErrInvalidRecord) GRPCStatus() *status.Status {
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("invalid record: %s", e.Reason))
+	d := &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       e.Field,
+			Description: e.Reason,
+		}},
+	}
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrInvalidRecord) Code() codes.Code {
+	return status.Code(e.GRPCStatus().Err())
+}
+
+func (e ErrInvalidRecord) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
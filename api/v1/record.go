@@ -0,0 +1,8 @@
+package log_v1
+
+import "google.golang.org/protobuf/proto"
+
+// レコードの最大サイズの判定に使うサイズ。HTTP・gRPC・ログのどの層でもこれで判定する
+func RecordSize(record *Record) uint64 {
+	return uint64(proto.Size(record))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+)
+
+const usage = `usage:
+  logtool export -dir <log dir> [-format jsonl|proto] [-out <file>]
+  logtool import -dir <log dir> [-format jsonl|proto] [-in <file>] [-resequence]
+                 [-max-store-bytes <n>] [-max-index-bytes <n>]`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dir := fs.String("dir", "", "log directory")
+	format := fs.String("format", "jsonl", "output format (jsonl|proto)")
+	out := fs.String("out", "", "output file (default: stdout)")
+	fs.Parse(args)
+
+	f, err := log.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	l, err := openLog(*dir, log.Config{})
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	n, err := l.Export(w, f)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d records\n", n)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := fs.String("dir", "", "log directory")
+	format := fs.String("format", "jsonl", "input format (jsonl|proto)")
+	in := fs.String("in", "", "input file (default: stdin)")
+	resequence := fs.Bool("resequence", false, "assign new offsets instead of preserving the original ones")
+	maxStoreBytes := fs.Uint64("max-store-bytes", 0, "segment max store bytes of the destination log")
+	maxIndexBytes := fs.Uint64("max-index-bytes", 0, "segment max index bytes of the destination log")
+	fs.Parse(args)
+
+	f, err := log.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	conf := log.Config{}
+	conf.Segment.MaxStoreBytes = *maxStoreBytes
+	conf.Segment.MaxIndexBytes = *maxIndexBytes
+	l, err := openLog(*dir, conf)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	n, err := l.Import(r, f, log.ImportOptions{PreserveOffsets: !*resequence})
+	if err != nil {
+		return fmt.Errorf("imported %d records: %w", n, err)
+	}
+	fmt.Fprintf(os.Stderr, "imported %d records\n", n)
+	return nil
+}
+
+func openLog(dir string, conf log.Config) (*log.Log, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("-dir is required")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return log.NewLog(dir, conf)
+}
@@ -2,13 +2,28 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
 	"github.com/gorilla/mux"
 )
 
+// リクエストのレコードの値以外の部分として許容するサイズ
+const requestOverheadBytes = 1024
+
+type Config struct {
+	// 1レコードの最大サイズ (0: 無制限)。api.RecordSize で判定する
+	MaxRecordBytes int64
+}
+
 func NewHttpServer(addr string) *http.Server {
+	return NewHttpServerWithConfig(addr, Config{})
+}
+
+func NewHttpServerWithConfig(addr string, config Config) *http.Server {
 	httpsrv := newHttpServer()
+	httpsrv.maxRecordBytes = config.MaxRecordBytes
 	router := mux.NewRouter()
 	router.HandleFunc("/", httpsrv.handleProduce).Methods("POST")
 	router.HandleFunc("/", httpsrv.handleConsume).Methods("GET")
@@ -19,7 +34,8 @@ func NewHttpServer(addr string) *http.Server {
 }
 
 type httpServer struct {
-	log Store
+	log            Store
+	maxRecordBytes int64
 }
 
 func newHttpServer() *httpServer {
@@ -53,8 +69,18 @@ type ConsumeResponse struct {
 func (s *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
+	if s.maxRecordBytes > 0 {
+		// 値は base64 でエンコードされるため 4/3 倍になる
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxRecordBytes*4/3+requestOverheadBytes)
+	}
 	var req ProduceRequest
-	resolveRequest(w, r, &req)
+	if err := resolveRequest(w, r, &req); err != nil {
+		return
+	}
+	if s.maxRecordBytes > 0 && recordSize(req.Record) > uint64(s.maxRecordBytes) {
+		http.Error(w, ErrRecordTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 
 	offset, err := s.log.Append(req.Record)
 	if err != nil {
@@ -66,11 +92,18 @@ func (s *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
 	resolveResponse(w, r, &res)
 }
 
+// gRPC のレコードと同じ定義でサイズを求める
+func recordSize(record LogRecord) uint64 {
+	return api.RecordSize(&api.Record{Value: record.Value, Offset: record.Offset})
+}
+
 func (s *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	var req ConsumeRequest
-	resolveRequest(w, r, &req)
+	if err := resolveRequest(w, r, &req); err != nil {
+		return
+	}
 
 	record, err := s.log.Read(req.Offset)
 	if err == ErrOffsetNotFound {
@@ -86,11 +119,17 @@ func (s *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 	resolveResponse(w, r, &res)
 }
 
-func resolveRequest[T ProduceRequest | ConsumeRequest](w http.ResponseWriter, r *http.Request, req *T) {
+func resolveRequest[T ProduceRequest | ConsumeRequest](w http.ResponseWriter, r *http.Request, req *T) error {
 	err := json.NewDecoder(r.Body).Decode(req)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, ErrRecordTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return err
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 	}
+	return err
 }
 
 func resolveResponse[T ProduceResponse | ConsumeResponse](w http.ResponseWriter, r *http.Request, res *T) {
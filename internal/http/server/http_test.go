@@ -194,3 +194,26 @@ func TestHandleProduceFailure(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleProduceTooLarge(t *testing.T) {
+	srv := newHttpServerWithLog(NewLog())
+	srv.maxRecordBytes = 8
+
+	cases := []struct {
+		title string
+		value []byte
+	}{
+		{title: "value exceeds max record bytes", value: []byte("0123456789")},
+		{title: "body exceeds limit", value: bytes.Repeat([]byte("0"), 2048)},
+	}
+	for _, tt := range cases {
+		t.Run(tt.title, func(t *testing.T) {
+			responseRecorder := httptest.NewRecorder()
+			executeProduceHandler(t, srv, ProduceRequest{Record: LogRecord{Value: tt.value}}, responseRecorder)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, responseRecorder.Code)
+
+			actual := strings.TrimRight(responseRecorder.Body.String(), "\n")
+			assert.Equal(t, ErrRecordTooLarge.Error(), actual)
+		})
+	}
+}
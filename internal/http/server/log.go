@@ -5,7 +5,10 @@ import (
 	"sync"
 )
 
-var ErrOffsetNotFound = fmt.Errorf("offset not found")
+var (
+	ErrOffsetNotFound = fmt.Errorf("offset not found")
+	ErrRecordTooLarge = fmt.Errorf("record too large")
+)
 
 type Store interface {
 	Append(record LogRecord) (uint64, error)
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/casbin/casbin/v2"
 	"google.golang.org/grpc/codes"
@@ -9,16 +10,26 @@ import (
 )
 
 type Authorizer struct {
+	mu       sync.RWMutex
 	enforcer *casbin.Enforcer
+	model    string
+	policy   string
+	// 最後に読み込んだポリシーファイルの状態。変更の検知に使う
+	loaded fileVersion
+	stop   chan struct{}
 }
 
 func New(model, plicy string) (*Authorizer, error) {
-	enforcer, err := casbin.NewEnforcer(model, plicy)
-	return &Authorizer{enforcer: enforcer}, err
+	a := &Authorizer{model: model, policy: plicy}
+	return a, a.Reload()
 }
 
 func (a *Authorizer) Authorize(subject, object, action string) error {
-	ok, err := a.enforcer.Enforce(subject, object, action)
+	a.mu.RLock()
+	enforcer := a.enforcer
+	a.mu.RUnlock()
+
+	ok, err := enforcer.Enforce(subject, object, action)
 	if err != nil {
 		return err
 	}
@@ -29,3 +40,37 @@ func (a *Authorizer) Authorize(subject, object, action string) error {
 	}
 	return nil
 }
+
+// ポリシーファイルを読み直す。不正なファイルの場合はエラーを返し、それまでのポリシーを使い続ける
+func (a *Authorizer) Reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reload()
+}
+
+func (a *Authorizer) reload() error {
+	version, err := statFile(a.policy)
+	if err != nil {
+		return err
+	}
+	enforcer, err := a.newEnforcer(a.policy)
+	if err != nil {
+		return err
+	}
+	a.enforcer = enforcer
+	a.loaded = version
+	return nil
+}
+
+// casbin はモデルに合わないルールを読み込むとパニックするので、エラーにして返す
+func (a *Authorizer) newEnforcer(policy string) (enforcer *casbin.Enforcer, err error) {
+	if _, err := readPolicy(policy); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			enforcer, err = nil, fmt.Errorf("load policy %s: %v", policy, r)
+		}
+	}()
+	return casbin.NewEnforcer(a.model, policy)
+}
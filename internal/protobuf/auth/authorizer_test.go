@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testModelFile = "../../../test/auth/model.conf"
+
+func TestAuthorize(t *testing.T) {
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(`p, root, *, *
+p, orders-producer, topic:orders.*, produce
+p, audit-reader, topic:audit, consume
+g, team-a, orders-producer
+g, team-a, audit-reader
+`), 0600))
+	authorizer, err := New(testModelFile, policy)
+	require.NoError(t, err)
+
+	for senario, tc := range map[string]struct {
+		subject, object, action string
+		allowed                 bool
+	}{
+		"wildcard subject":           {"root", "admin:Flush", "admin", true},
+		"produce to matching topic":  {"team-a", "topic:orders.created", "produce", true},
+		"consume from matching":      {"team-a", "topic:audit", "consume", true},
+		"consume from produce topic": {"team-a", "topic:orders.created", "consume", false},
+		"produce to audit":           {"team-a", "topic:audit", "produce", false},
+		"prefix is not a glob":       {"team-a", "topic:orders", "produce", false},
+		"unknown subject":            {"nobody", "topic:audit", "consume", false},
+	} {
+		t.Run(senario, func(t *testing.T) {
+			err := authorizer.Authorize(tc.subject, tc.object, tc.action)
+			if tc.allowed {
+				require.NoError(t, err)
+			} else {
+				require.Equal(t, codes.PermissionDenied, status.Code(err))
+			}
+		})
+	}
+}
+
+func TestAuthorizePrincipal(t *testing.T) {
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(`p, user:alice, topic:orders, produce
+p, attr:trust_domain=example.org, topic:public.*, consume
+g, attr:role=auditor, audit-reader
+p, audit-reader, topic:audit, consume
+`), 0600))
+	authorizer, err := New(testModelFile, policy)
+	require.NoError(t, err)
+
+	principal := Principal{
+		Name:       "spiffe://example.org/ns/default/sa/orders",
+		Attributes: map[string]string{"trust_domain": "example.org", "role": "auditor"},
+	}
+	require.NoError(t, authorizer.AuthorizePrincipal(principal, "topic:public.news", "consume"))
+	require.NoError(t, authorizer.AuthorizePrincipal(principal, "topic:audit", "consume"))
+	err = authorizer.AuthorizePrincipal(principal, "topic:orders", "produce")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Contains(t, err.Error(), principal.Name)
+
+	require.NoError(t, authorizer.AuthorizePrincipal(Principal{Name: "alice"}, "topic:orders", "produce"))
+
+	// 名前と属性は接頭辞で区別するので、属性と同じ文字列の名前や名前と同じ文字列の属性では認可されない
+	for _, p := range []Principal{
+		{Name: "role:auditor"},
+		{Name: "attr:role=auditor"},
+		{Name: "audit-reader"},
+		{Name: "bob", Attributes: map[string]string{"user": "alice"}},
+		{Name: "bob", Attributes: map[string]string{"user:alice": ""}},
+	} {
+		require.Error(t, authorizer.AuthorizePrincipal(p, "topic:audit", "consume"))
+		require.Error(t, authorizer.AuthorizePrincipal(p, "topic:orders", "produce"))
+	}
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package auth
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const lockfileExclusiveLock = 0x2
+
+var lockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// ファイルに排他ロックを取る。ファイルを閉じると解放される
+func lockFile(f *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := lockFileEx.Call(f.Fd(), lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	policyType = "p" // sub, obj, act
+	roleType   = "g" // ユーザー, ロール
+)
+
+// ポリシーファイルの1行。Type は p または g
+type Rule struct {
+	Type   string
+	Values []string
+}
+
+func (r Rule) validate() error {
+	var want int
+	switch r.Type {
+	case policyType:
+		want = 3
+	case roleType:
+		want = 2
+	default:
+		return fmt.Errorf("unknown rule type: %q", r.Type)
+	}
+	if len(r.Values) != want {
+		return fmt.Errorf("rule %s must have %d values: %v", r.Type, want, r.Values)
+	}
+	for _, v := range r.Values {
+		if v == "" || strings.ContainsAny(v, ",\r\n") {
+			return fmt.Errorf("invalid value in rule %s: %q", r.Type, v)
+		}
+	}
+	return nil
+}
+
+func (r Rule) equal(other Rule) bool {
+	return r.String() == other.String()
+}
+
+func (r Rule) String() string {
+	return strings.Join(append([]string{r.Type}, r.Values...), ", ")
+}
+
+// ポリシーファイルの1行。ルールでない行 (空行とコメント行) は rule が nil
+type policyLine struct {
+	raw  string
+	rule *Rule
+}
+
+// 空行と # から始まるコメント行は読み飛ばす
+func readPolicy(name string) ([]Rule, error) {
+	lines, err := parsePolicy(name)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	for _, line := range lines {
+		if line.rule != nil {
+			rules = append(rules, *line.rule)
+		}
+	}
+	return rules, nil
+}
+
+func parsePolicy(name string) ([]policyLine, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	content := strings.TrimSuffix(string(b), "\n")
+	if content == "" {
+		return nil, nil
+	}
+	var lines []policyLine
+	for i, raw := range strings.Split(content, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			lines = append(lines, policyLine{raw: raw})
+			continue
+		}
+		fields := strings.Split(line, ",")
+		for j := range fields {
+			fields[j] = strings.TrimSpace(fields[j])
+		}
+		rule := Rule{Type: fields[0], Values: fields[1:]}
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, i+1, err)
+		}
+		lines = append(lines, policyLine{raw: raw, rule: &rule})
+	}
+	return lines, nil
+}
+
+// 更新後のルールをファイルの行に戻す。コメント行・空行と残ったルールの行はそのままの位置に残し、
+// 追加したルールは末尾に書く
+func mergePolicy(lines []policyLine, rules []Rule) []string {
+	remaining := map[string]int{}
+	for _, rule := range rules {
+		remaining[rule.String()]++
+	}
+	var merged []string
+	for _, line := range lines {
+		if line.rule != nil {
+			key := line.rule.String()
+			if remaining[key] == 0 {
+				continue
+			}
+			remaining[key]--
+		}
+		merged = append(merged, line.raw)
+	}
+	for _, rule := range rules {
+		if key := rule.String(); remaining[key] > 0 {
+			remaining[key]--
+			merged = append(merged, key)
+		}
+	}
+	return merged
+}
+
+// 一時ファイルに書き、validate で確かめてからリネームする。
+// 読み込み途中のファイルや読み込めないファイルを他のノードに見せない
+func writePolicy(name string, lines []string, validate func(name string) error) error {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := validate(tmp.Name()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// 同じポリシーファイルを共有する他のプロセスと更新を排他する。
+// ポリシーファイルはリネームで置き換えるので、ロックは別のファイルに取る
+func lockPolicy(name string) (*os.File, error) {
+	f, err := os.OpenFile(name+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(name string) (fileVersion, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// 現在のポリシーの一覧を返す
+func (a *Authorizer) Policies() ([]Rule, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return readPolicy(a.policy)
+}
+
+// ポリシーファイルに追記して読み直す。既にある場合は何もしない
+func (a *Authorizer) AddPolicy(rule Rule) error {
+	if err := rule.validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return a.update(func(rules []Rule) []Rule {
+		for _, r := range rules {
+			if r.equal(rule) {
+				return rules
+			}
+		}
+		return append(rules, rule)
+	})
+}
+
+// ポリシーファイルから削除して読み直す。存在しない場合は NotFound を返す
+func (a *Authorizer) RemovePolicy(rule Rule) error {
+	found := false
+	err := a.update(func(rules []Rule) []Rule {
+		var kept []Rule
+		for _, r := range rules {
+			if r.equal(rule) {
+				found = true
+				continue
+			}
+			kept = append(kept, r)
+		}
+		return kept
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return status.Errorf(codes.NotFound, "policy not found: %s", rule)
+	}
+	return nil
+}
+
+// 他のプロセスの更新を上書きしないよう、ファイルをロックしてから読み直して書き換える
+func (a *Authorizer) update(fn func([]Rule) []Rule) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	lock, err := lockPolicy(a.policy)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	lines, err := parsePolicy(a.policy)
+	if err != nil {
+		return err
+	}
+	var rules []Rule
+	for _, line := range lines {
+		if line.rule != nil {
+			rules = append(rules, *line.rule)
+		}
+	}
+	validate := func(name string) error {
+		_, err := a.newEnforcer(name)
+		return err
+	}
+	if err := writePolicy(a.policy, mergePolicy(lines, fn(rules)), validate); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid policy: %v", err)
+	}
+	return a.reload()
+}
+
+// ポリシーファイルの変更を interval ごとに確認して読み直す。
+// 複数のノードで同じファイルを共有すれば、他のノードでの変更も反映される。
+// 読み直しに失敗した場合は onError に渡す (nil: 標準のロガーに書く)
+func (a *Authorizer) Watch(interval time.Duration, onError func(error)) {
+	if onError == nil {
+		onError = func(err error) {
+			log.Printf("reload policy %s: %v", a.policy, err)
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	a.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := a.reloadIfChanged(); err != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (a *Authorizer) reloadIfChanged() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	version, err := statFile(a.policy)
+	if err != nil || version == a.loaded {
+		return err
+	}
+	if err := a.reload(); err != nil {
+		// 同じ内容で何度も読み直さない。ファイルが直されたら読み込む
+		a.loaded = version
+		return err
+	}
+	return nil
+}
+
+func (a *Authorizer) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func setupPolicy(t *testing.T, content string) (*Authorizer, string) {
+	t.Helper()
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(content), 0600))
+	authorizer, err := New(testModelFile, policy)
+	require.NoError(t, err)
+	t.Cleanup(authorizer.Close)
+	return authorizer, policy
+}
+
+func TestReload(t *testing.T) {
+	authorizer, policy := setupPolicy(t, "p, alice, topic:orders, produce\n")
+	require.NoError(t, authorizer.Authorize("alice", "topic:orders", "produce"))
+
+	t.Run("valid file is applied", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(policy, []byte("# comment\np, bob, topic:orders, produce\n"), 0600))
+		require.NoError(t, authorizer.Reload())
+		require.Error(t, authorizer.Authorize("alice", "topic:orders", "produce"))
+		require.NoError(t, authorizer.Authorize("bob", "topic:orders", "produce"))
+	})
+
+	t.Run("invalid file keeps old policy", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(policy, []byte("p, alice, topic:orders\n"), 0600))
+		require.Error(t, authorizer.Reload())
+		require.NoError(t, authorizer.Authorize("bob", "topic:orders", "produce"))
+	})
+}
+
+// 監視中に書きかけのファイルを読まないよう、リネームで置き換える
+func replaceFile(t *testing.T, name, content string) {
+	t.Helper()
+	tmp := name + ".new"
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0600))
+	require.NoError(t, os.Rename(tmp, name))
+}
+
+func TestWatch(t *testing.T) {
+	authorizer, policy := setupPolicy(t, "p, alice, topic:orders, produce\n")
+	errs := make(chan error, 10)
+	authorizer.Watch(10*time.Millisecond, func(err error) { errs <- err })
+
+	// 更新時刻の分解能が粗いファイルシステムでも変更と分かるよう、サイズも変える
+	replaceFile(t, policy, "# bob only\np, bob, topic:orders.*, produce\n")
+	require.Eventually(t, func() bool {
+		return authorizer.Authorize("bob", "topic:orders.created", "produce") == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// 読み直しに失敗したことを知らせ、それまでのポリシーを使い続ける
+	replaceFile(t, policy, "p, carol\n")
+	select {
+	case err := <-errs:
+		require.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("reload error was not reported")
+	}
+	require.NoError(t, authorizer.Authorize("bob", "topic:orders.created", "produce"))
+}
+
+func TestManagePolicies(t *testing.T) {
+	authorizer, policy := setupPolicy(t, "p, alice, topic:orders, produce\n")
+	rule := Rule{Type: "p", Values: []string{"bob", "topic:audit", "consume"}}
+
+	require.NoError(t, authorizer.AddPolicy(rule))
+	require.NoError(t, authorizer.AddPolicy(rule))
+	require.NoError(t, authorizer.Authorize("bob", "topic:audit", "consume"))
+	rules, err := authorizer.Policies()
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+
+	err = authorizer.AddPolicy(Rule{Type: "p", Values: []string{"bob", "topic:a,b", "consume"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// 再起動後も残る
+	restarted, err := New(testModelFile, policy)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Authorize("bob", "topic:audit", "consume"))
+
+	require.NoError(t, authorizer.RemovePolicy(rule))
+	require.Error(t, authorizer.Authorize("bob", "topic:audit", "consume"))
+	err = authorizer.RemovePolicy(rule)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestUpdateKeepsComments(t *testing.T) {
+	content := "# writers\np, alice, topic:orders, produce\n\n# readers\np, carol, topic:orders, consume\n"
+	authorizer, policy := setupPolicy(t, content)
+
+	rule := Rule{Type: "p", Values: []string{"bob", "topic:audit", "consume"}}
+	require.NoError(t, authorizer.AddPolicy(rule))
+	require.NoError(t, authorizer.RemovePolicy(Rule{Type: "p", Values: []string{"carol", "topic:orders", "consume"}}))
+
+	b, err := os.ReadFile(policy)
+	require.NoError(t, err)
+	require.Equal(t, "# writers\np, alice, topic:orders, produce\n\n# readers\np, bob, topic:audit, consume\n", string(b))
+}
+
+func TestUpdateValidatesBeforeReplacing(t *testing.T) {
+	// ロールの定義のないモデルではロールのルールを読み込めない
+	model := filepath.Join(t.TempDir(), "model.conf")
+	require.NoError(t, os.WriteFile(model, []byte(`[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`), 0600))
+	content := "p, alice, topic:orders, produce\n"
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(content), 0600))
+	authorizer, err := New(model, policy)
+	require.NoError(t, err)
+
+	err = authorizer.AddPolicy(Rule{Type: "g", Values: []string{"bob", "writer"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	b, err := os.ReadFile(policy)
+	require.NoError(t, err)
+	require.Equal(t, content, string(b))
+	require.NoError(t, authorizer.Authorize("alice", "topic:orders", "produce"))
+}
+
+func TestUpdateAcrossProcesses(t *testing.T) {
+	_, policy := setupPolicy(t, "p, alice, topic:orders, produce\n")
+
+	// 同じファイルを共有する別々の Authorizer から並行して追加しても、どの追加も失われない
+	const writers = 4
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		authorizer, err := New(testModelFile, policy)
+		require.NoError(t, err)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				rule := Rule{Type: "p", Values: []string{fmt.Sprintf("user-%d-%d", i, j), "topic:orders", "consume"}}
+				require.NoError(t, authorizer.AddPolicy(rule))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	rules, err := readPolicy(policy)
+	require.NoError(t, err)
+	require.Len(t, rules, 1+writers*5)
+}
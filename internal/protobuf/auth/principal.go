@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	userSubjectPrefix      = "user:"
+	attributeSubjectPrefix = "attr:"
+)
+
+// 認証されたクライアント
+type Principal struct {
+	Name string
+	// 認証方式やトークンのクレームなど。ポリシーでは "attr:key=value" のサブジェクトとして照合する
+	Attributes map[string]string
+}
+
+// 名前、属性の順に照合するサブジェクト。名前と属性が同じサブジェクトにならないよう、
+// 名前は "user:name"、属性は "attr:key=value" とする
+func (p Principal) subjects() []string {
+	subjects := []string{userSubjectPrefix + p.Name}
+	keys := make([]string, 0, len(p.Attributes))
+	for k := range p.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		subjects = append(subjects, attributeSubjectPrefix+k+"="+p.Attributes[k])
+	}
+	return subjects
+}
+
+// 名前か属性のいずれかで許可されていれば認可する。拒否した場合は名前での拒否理由を返す
+func (a *Authorizer) AuthorizePrincipal(principal Principal, object, action string) error {
+	var denied error
+	for _, subject := range principal.subjects() {
+		err := a.Authorize(subject, object, action)
+		if err == nil {
+			return nil
+		}
+		if status.Code(err) != codes.PermissionDenied {
+			return err
+		}
+		if denied == nil {
+			denied = err
+		}
+	}
+	return denied
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 読み込んだ証明書一式。読み直す場合は全体を作り直して差し替える
+type tlsMaterial struct {
+	cert    *tls.Certificate
+	ca      *x509.CertPool
+	revoked revocationList
+}
+
+func loadTLSMaterial(cfg TLSConfig) (*tlsMaterial, error) {
+	m := &tlsMaterial{}
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+		m.cert = &cert
+	}
+
+	var caCerts []*x509.Certificate
+	if cfg.CAFile != "" {
+		var err error
+		if caCerts, err = readCertificates(cfg.CAFile); err != nil {
+			return nil, err
+		}
+		m.ca = x509.NewCertPool()
+		for _, c := range caCerts {
+			m.ca.AddCert(c)
+		}
+	}
+
+	m.revoked = revocationList{}
+	if cfg.CRLFile != "" {
+		if err := m.revoked.loadCRL(cfg.CRLFile, caCerts); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.DeniedSerialsFile != "" {
+		if err := m.revoked.loadDeniedSerials(cfg.DeniedSerialsFile); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func readCertificates(name string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("failed to parse root certificate: %q", name)
+	}
+	return certs, nil
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+type certReloader struct {
+	cfg TLSConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	material *tlsMaterial
+	versions map[string]fileVersion
+	checked  time.Time
+}
+
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	r := &certReloader{cfg: cfg, now: time.Now}
+	versions, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	material, err := loadTLSMaterial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.material, r.versions, r.checked = material, versions, r.now()
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	var files []string
+	for _, name := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile, r.cfg.CRLFile, r.cfg.DeniedSerialsFile} {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+func (r *certReloader) stat() (map[string]fileVersion, error) {
+	versions := map[string]fileVersion{}
+	for _, name := range r.files() {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		versions[name] = fileVersion{modTime: fi.ModTime(), size: fi.Size()}
+	}
+	return versions, nil
+}
+
+// 確認の間隔が過ぎていればファイルの変更を確認し、変更されていれば読み直してから返す。
+// 証明書と鍵の書き換えの途中などで読み込みに失敗した場合は、次の確認で読み直す
+func (r *certReloader) current() *tlsMaterial {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cfg.ReloadInterval <= 0 {
+		return r.material
+	}
+	now := r.now()
+	if now.Sub(r.checked) < r.cfg.ReloadInterval {
+		return r.material
+	}
+	r.checked = now
+	versions, err := r.stat()
+	if err != nil || sameVersions(versions, r.versions) {
+		return r.material
+	}
+	if material, err := loadTLSMaterial(r.cfg); err == nil {
+		r.material, r.versions = material, versions
+	}
+	return r.material
+}
+
+func sameVersions(a, b map[string]fileVersion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, v := range a {
+		if b[name] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m := r.current()
+	if m.cert == nil {
+		return nil, errors.New("no certificate is configured")
+	}
+	return m.cert, nil
+}
+
+// 証明書がない場合は空の証明書を返し、サーバ側で拒否させる
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if m := r.current(); m.cert != nil {
+		return m.cert, nil
+	}
+	return &tls.Certificate{}, nil
+}
+
+func (r *certReloader) configForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = r.current().ca
+		c.VerifyPeerCertificate = r.verifyPeerCertificate
+		return c, nil
+	}
+}
+
+// 標準の検証を通った証明書チェーンが失効していないか確認する
+func (r *certReloader) verifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	revoked := r.current().revoked
+	for _, chain := range verifiedChains {
+		if err := revoked.check(chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// サーバの証明書を現在の CA で検証し、失効していないか確認する
+func (r *certReloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	m := r.current()
+	intermediates := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	chains, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         m.ca,
+		Intermediates: intermediates,
+		DNSName:       cs.ServerName,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		if err := m.revoked.check(chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// 拒否する証明書のシリアル番号 (16進数)
+type revocationList map[string]bool
+
+func (l revocationList) check(chain []*x509.Certificate) error {
+	for _, c := range chain {
+		if l[c.SerialNumber.Text(16)] {
+			return fmt.Errorf("certificate %q (serial %x) is revoked", c.Subject.CommonName, c.SerialNumber)
+		}
+	}
+	return nil
+}
+
+// PEM または DER の CRL を読み込む。CA のいずれかで署名を検証できない CRL は受け付けない
+func (l revocationList) loadCRL(name string, caCerts []*x509.Certificate) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	signed := false
+	for _, ca := range caCerts {
+		if crl.CheckSignatureFrom(ca) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return fmt.Errorf("%s: CRL is not signed by the CA", name)
+	}
+	for _, revoked := range crl.RevokedCertificates {
+		l[revoked.SerialNumber.Text(16)] = true
+	}
+	return nil
+}
+
+// 空行と # から始まるコメント行は読み飛ばす。"0a:1b" のようなコロン区切りも受け付ける
+func (l revocationList) loadDeniedSerials(name string) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(strings.ReplaceAll(line, ":", ""), 16)
+		if !ok {
+			return fmt.Errorf("%s:%d: invalid serial number: %q", name, i+1, line)
+		}
+		l[serial.Text(16)] = true
+	}
+	return nil
+}
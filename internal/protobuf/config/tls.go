@@ -2,9 +2,7 @@ package config
 
 import (
 	"crypto/tls"
-	"crypto/x509"
-	"fmt"
-	"os"
+	"time"
 )
 
 type TLSConfig struct {
@@ -13,35 +11,39 @@ type TLSConfig struct {
 	CAFile        string
 	ServerAddress string
 	Server        bool
+	// 0 より大きい場合、ハンドシェイクのたびに最長この間隔でファイルの変更を確認して読み直す。
+	// 読み込みに失敗した場合はそれまでの証明書を使い続ける
+	ReloadInterval time.Duration
+	// 設定されている場合、CA が署名した CRL で失効した証明書を拒否する
+	CRLFile string
+	// 設定されている場合、記載したシリアル番号 (1行に1つ、16進数) の証明書を拒否する
+	DeniedSerialsFile string
 }
 
+// 証明書・CA は固定せずフックから読み出すため、ファイルを差し替えれば再起動せずに反映される
 func SetupTlsConfig(cfg TLSConfig) (*tls.Config, error) {
+	r, err := newCertReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
 	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS13}
-	if cfg.CertFile != "" && cfg.KeyFile != "" {
-		var err error
-		tlsConfig.Certificates = make([]tls.Certificate, 1)
-		tlsConfig.Certificates[0], err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
-		if err != nil {
-			return nil, err
+	if cfg.Server {
+		tlsConfig.GetCertificate = r.getCertificate
+		if cfg.CAFile != "" {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			// ClientCAs はハンドシェイクごとに現在の CA で差し替える
+			tlsConfig.GetConfigForClient = r.configForClient(tlsConfig)
 		}
+		return tlsConfig, nil
 	}
 
+	tlsConfig.GetClientCertificate = r.getClientCertificate
 	if cfg.CAFile != "" {
-		b, err := os.ReadFile(cfg.CAFile)
-		if err != nil {
-			return nil, err
-		}
-		ca := x509.NewCertPool()
-		if !ca.AppendCertsFromPEM(b) {
-			return nil, fmt.Errorf("failed to parse root certificate: %q", cfg.CAFile)
-		}
-
-		if cfg.Server {
-			tlsConfig.ClientCAs = ca
-			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-		} else {
-			tlsConfig.RootCAs = ca
-		}
+		// 標準の検証は RootCAs を固定してしまうため、VerifyConnection で現在の CA を使って検証する
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = r.verifyServer
+	} else {
+		tlsConfig.VerifyPeerCertificate = r.verifyPeerCertificate
 	}
 	return tlsConfig, nil
 }
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	ca := &testCA{dir: t.TempDir(), cert: cert, key: key}
+	writePEM(t, ca.path("ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+// name.pem と name-key.pem に証明書と鍵を書き込む
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certFile, keyFile = ca.path(name+".pem"), ca.path(name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func (ca *testCA) writeCRL(t *testing.T, name string, serials ...int64) string {
+	t.Helper()
+	var revoked []pkix.RevokedCertificate
+	for _, s := range serials {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: big.NewInt(s), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          time.Now(),
+		NextUpdate:          time.Now().Add(time.Hour),
+		RevokedCertificates: revoked,
+	}, ca.cert, ca.key)
+	require.NoError(t, err)
+	writePEM(t, ca.path(name), "X509 CRL", der)
+	return ca.path(name)
+}
+
+// 変更を確実に検知させるため、更新時刻も進める
+func writePEM(t *testing.T, name, typ string, der []byte) {
+	t.Helper()
+	writeFile(t, name, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}))
+}
+
+var fileClock = time.Now()
+
+func writeFile(t *testing.T, name string, b []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(name, b, 0600))
+	fileClock = fileClock.Add(time.Second)
+	require.NoError(t, os.Chtimes(name, fileClock, fileClock))
+}
+
+// ループバックでハンドシェイクし、クライアントが受け取ったサーバの証明書のシリアル番号を返す。
+// TLS 1.3 ではクライアント証明書の拒否はクライアントのハンドシェイク完了後に届くため、読み出しまで行う
+func handshake(t *testing.T, serverConfig, clientConfig *tls.Config) (int64, error) {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer l.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		err = conn.(*tls.Conn).Handshake()
+		if err == nil {
+			_, err = conn.Write([]byte("ok"))
+		}
+		serverErr <- err
+	}()
+
+	clientConfig = clientConfig.Clone()
+	clientConfig.ServerName = "localhost"
+	conn, err := tls.Dial("tcp", l.Addr().String(), clientConfig)
+	if err != nil {
+		<-serverErr
+		return 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 2)); err != nil {
+		<-serverErr
+		return 0, err
+	}
+	if err := <-serverErr; err != nil {
+		return 0, err
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func setupTLS(t *testing.T, ca *testCA, server, client TLSConfig) (*tls.Config, *tls.Config) {
+	t.Helper()
+	server.CertFile, server.KeyFile = ca.issue(t, "server", 10, x509.ExtKeyUsageServerAuth)
+	server.CAFile, server.Server = ca.path("ca.pem"), true
+	if client.CertFile == "" {
+		client.CertFile, client.KeyFile = ca.issue(t, "client", 20, x509.ExtKeyUsageClientAuth)
+	}
+	client.CAFile = ca.path("ca.pem")
+	serverConfig, err := SetupTlsConfig(server)
+	require.NoError(t, err)
+	clientConfig, err := SetupTlsConfig(client)
+	require.NoError(t, err)
+	return serverConfig, clientConfig
+}
+
+func TestTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	reload := TLSConfig{ReloadInterval: time.Nanosecond}
+	serverConfig, clientConfig := setupTLS(t, ca, reload, reload)
+
+	serial, err := handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), serial)
+
+	// 証明書を差し替えると再起動せずに使われる
+	ca.issue(t, "server", 11, x509.ExtKeyUsageServerAuth)
+	serial, err = handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+	require.Equal(t, int64(11), serial)
+
+	// 壊れたファイルは読み込まず、それまでの証明書を使い続ける
+	writeFile(t, ca.path("server.pem"), []byte("broken"))
+	serial, err = handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+	require.Equal(t, int64(11), serial)
+
+	// CA ごと差し替えても、両側が読み直して接続できる
+	rotated := newTestCA(t)
+	rotated.dir = ca.dir
+	writePEM(t, ca.path("ca.pem"), "CERTIFICATE", rotated.cert.Raw)
+	rotated.issue(t, "server", 12, x509.ExtKeyUsageServerAuth)
+	rotated.issue(t, "client", 21, x509.ExtKeyUsageClientAuth)
+	serial, err = handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+	require.Equal(t, int64(12), serial)
+}
+
+func TestTLSRevocation(t *testing.T) {
+	for senario, fn := range map[string]func(*testing.T, *testCA){
+		"denied serial":          testDeniedSerial,
+		"crl":                    testCRL,
+		"crl of another ca":      testCRLOfAnotherCA,
+		"no client certificate":  testNoClientCertificate,
+		"server is not in chain": testUntrustedServer,
+	} {
+		t.Run(senario, func(t *testing.T) {
+			fn(t, newTestCA(t))
+		})
+	}
+}
+
+func testDeniedSerial(t *testing.T, ca *testCA) {
+	denied := ca.path("denied.txt")
+	writeFile(t, denied, []byte("# 漏洩した証明書\n"))
+	serverConfig, clientConfig := setupTLS(t, ca,
+		TLSConfig{DeniedSerialsFile: denied, ReloadInterval: time.Nanosecond}, TLSConfig{},
+	)
+	_, err := handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+
+	writeFile(t, denied, []byte(fmt.Sprintf("# 漏洩した証明書\n%x\n", 20)))
+	_, err = handshake(t, serverConfig, clientConfig)
+	require.Error(t, err)
+
+	// クライアントもサーバの証明書を拒否できる
+	writeFile(t, denied, []byte("00:0a\n"))
+	_, clientConfig = setupTLS(t, ca, TLSConfig{}, TLSConfig{DeniedSerialsFile: denied})
+	_, err = handshake(t, serverConfig, clientConfig)
+	require.Error(t, err)
+}
+
+func testCRL(t *testing.T, ca *testCA) {
+	crl := ca.writeCRL(t, "crl.pem", 20)
+	serverConfig, clientConfig := setupTLS(t, ca, TLSConfig{CRLFile: crl}, TLSConfig{})
+	_, err := handshake(t, serverConfig, clientConfig)
+	require.Error(t, err)
+
+	// 失効していない証明書は受け入れる
+	certFile, keyFile := ca.issue(t, "other-client", 22, x509.ExtKeyUsageClientAuth)
+	_, clientConfig = setupTLS(t, ca, TLSConfig{CRLFile: crl}, TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	_, err = handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+}
+
+func testCRLOfAnotherCA(t *testing.T, ca *testCA) {
+	other := newTestCA(t)
+	_, err := SetupTlsConfig(TLSConfig{
+		CAFile:  ca.path("ca.pem"),
+		CRLFile: other.writeCRL(t, "crl.pem", 20),
+		Server:  true,
+	})
+	require.Error(t, err)
+}
+
+func testNoClientCertificate(t *testing.T, ca *testCA) {
+	serverConfig, _ := setupTLS(t, ca, TLSConfig{}, TLSConfig{})
+	clientConfig, err := SetupTlsConfig(TLSConfig{CAFile: ca.path("ca.pem")})
+	require.NoError(t, err)
+	_, err = handshake(t, serverConfig, clientConfig)
+	require.Error(t, err)
+}
+
+func testUntrustedServer(t *testing.T, ca *testCA) {
+	serverConfig, _ := setupTLS(t, ca, TLSConfig{}, TLSConfig{})
+	other := newTestCA(t)
+	certFile, keyFile := other.issue(t, "client", 20, x509.ExtKeyUsageClientAuth)
+	clientConfig, err := SetupTlsConfig(TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: other.path("ca.pem")})
+	require.NoError(t, err)
+	_, err = handshake(t, serverConfig, clientConfig)
+	require.Error(t, err)
+}
@@ -0,0 +1,119 @@
+package log
+
+import (
+	"fmt"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// 複数のレコードをまとめて追記し、先頭のオフセットを返す。オフセットは連続して割り当てる。
+// 途中で書き込みに失敗した場合は書き込んだ分を切り捨て、1件も追記しなかったことにする
+func (l *Log) AppendBatch(records []*api.Record) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(records) == 0 {
+		return 0, api.ErrInvalidRecord{Field: "records", Reason: "batch is empty"}
+	}
+	now := time.Now()
+	var size uint64
+	prev := l.lastTimestamp
+	for _, record := range records {
+		if record.Control != api.ControlType_CONTROL_NONE {
+			return 0, api.ErrInvalidRecord{
+				Field:  "records.control",
+				Reason: fmt.Sprintf("control record cannot be appended: %s", record.Control),
+			}
+		}
+		if record.BlobRef != "" {
+			return 0, api.ErrInvalidRecord{
+				Field:  "records.blob_ref",
+				Reason: fmt.Sprintf("blob reference cannot be appended: %s", record.BlobRef),
+			}
+		}
+		// 重複排除はレコード単位でしかできないので、バッチでは受け付けない
+		if record.ProducerId != "" {
+			return 0, api.ErrInvalidRecord{
+				Field:  "records.producer_id",
+				Reason: fmt.Sprintf("idempotent producer cannot be used in batch: %s", record.ProducerId),
+			}
+		}
+		setExpiry(record, now)
+		setTimestamp(record, now, prev)
+		prev = record.Timestamp
+		if err := l.checkRecordSize(record); err != nil {
+			return 0, err
+		}
+		if record.TransactionId != "" {
+			if _, ok := l.txns.Open[record.TransactionId]; !ok {
+				return 0, api.ErrTransactionNotFound{TransactionId: record.TransactionId}
+			}
+		}
+		size += uint64(proto.Size(record)) + lenWidth + entryWidth
+	}
+	// 途中で容量不足にならないよう、ロールで作るセグメントのインデックスも含めて先に確認する
+	size += l.conf.Segment.MaxIndexBytes * (size/l.conf.Segment.MaxStoreBytes + 1)
+	if err := l.checkStorageFor(size); err != nil {
+		return 0, err
+	}
+
+	first := l.activeSegment.nextOffset
+	stored := make([]*api.Record, len(records))
+	for i, record := range records {
+		s, err := l.offload(record)
+		if err == nil {
+			_, err = l.write(s)
+		}
+		if err != nil {
+			if terr := l.truncateAfter(first); terr != nil {
+				return 0, fmt.Errorf("%v: rollback failed: %w", err, terr)
+			}
+			return 0, err
+		}
+		stored[i] = s
+	}
+	for i, s := range stored {
+		records[i].Offset = s.Offset
+		l.applyAppended(s, now)
+	}
+	l.notifyChanged()
+	return first, nil
+}
+
+// from から to の手前まで (to が 0 の場合は末尾まで) のレコードを最大 limit 件読み、次に読むオフセットを返す。
+// 期限切れのレコードと、read_committed で除外するレコードは読み飛ばす
+func (l *Log) ReadRange(from, to uint64, limit int, isolation api.IsolationLevel) ([]*api.Record, uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	end := l.activeSegment.nextOffset
+	if from < l.segments[0].baseOffset || from > end {
+		return nil, 0, api.ErrOffsetOutOfRange{Offset: from}
+	}
+	committed := isolation == api.IsolationLevel_READ_COMMITTED
+	if committed {
+		end = l.txns.lastStableOffset(end)
+	}
+	if to > 0 && to < end {
+		end = to
+	}
+	now := time.Now()
+	var records []*api.Record
+	off := from
+	for ; off < end && len(records) < limit; off++ {
+		record, err := l.read(off)
+		if err != nil {
+			return nil, 0, err
+		}
+		if committed && (record.Control != api.ControlType_CONTROL_NONE || l.txns.isAborted(record)) {
+			continue
+		}
+		if isExpired(record, now) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, off, nil
+}
@@ -0,0 +1,116 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func newBatch(from, to int) []*api.Record {
+	var records []*api.Record
+	for i := from; i < to; i++ {
+		records = append(records, &api.Record{Value: []byte(fmt.Sprintf("%d", i))})
+	}
+	return records
+}
+
+func TestAppendBatch(t *testing.T) {
+	fs := NewFaultFS(NewMemFS())
+	require.NoError(t, fs.MkdirAll("/log", 0700))
+	conf := newMemConfig(fs)
+	log, err := NewLog("/log", conf)
+	require.NoError(t, err)
+
+	first, err := log.AppendBatch(newBatch(0, 5))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), first)
+	requireValues(t, log, 5)
+	require.Greater(t, len(log.segments), 1)
+
+	t.Run("invalid record rejects whole batch", func(t *testing.T) {
+		records := newBatch(5, 7)
+		records[1].ProducerId = "producer"
+		_, err := log.AppendBatch(records)
+		require.ErrorAs(t, err, &api.ErrInvalidRecord{})
+		requireValues(t, log, 5)
+	})
+
+	t.Run("failed write is rolled back", func(t *testing.T) {
+		injected := errors.New("disk failure")
+		// 2回目のロールで失敗させ、1回目のロールで作ったセグメントも削除させる
+		var rolled string
+		fs.SetWriteFault(func(name string) error {
+			if !strings.HasSuffix(name, configFileExtention) {
+				return nil
+			}
+			if rolled == "" {
+				rolled = name
+			}
+			if name != rolled {
+				return injected
+			}
+			return nil
+		})
+		segments := len(log.segments)
+		_, err := log.AppendBatch(newBatch(5, 10))
+		require.ErrorIs(t, err, injected)
+		require.Len(t, log.segments, segments)
+		requireValues(t, log, 5)
+
+		fs.SetWriteFault(nil)
+		first, err := log.AppendBatch(newBatch(5, 10))
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), first)
+		requireValues(t, log, 10)
+	})
+
+	require.NoError(t, log.Close())
+	log, err = NewLog("/log", conf)
+	require.NoError(t, err)
+	defer log.Close()
+	requireValues(t, log, 10)
+	require.NoError(t, log.Verify())
+}
+
+func TestReadRange(t *testing.T) {
+	fs := NewMemFS()
+	require.NoError(t, fs.MkdirAll("/log", 0700))
+	log, err := NewLog("/log", newMemConfig(fs))
+	require.NoError(t, err)
+	defer log.Close()
+	appendValues(t, log, 0, 5)
+	_, err = log.Append(&api.Record{Value: []byte("expired"), ExpiresAt: time.Now().Add(-time.Second).UnixMilli()})
+	require.NoError(t, err)
+	appendValues(t, log, 6, 8)
+
+	for senario, tc := range map[string]struct {
+		from, to uint64
+		limit    int
+		want     []string
+		next     uint64
+	}{
+		"limit":               {from: 1, limit: 2, want: []string{"1", "2"}, next: 3},
+		"to":                  {from: 3, to: 5, limit: 10, want: []string{"3", "4"}, next: 5},
+		"skip expired record": {from: 4, limit: 2, want: []string{"4", "6"}, next: 7},
+		"end of log":          {from: 8, limit: 10, next: 8},
+	} {
+		t.Run(senario, func(t *testing.T) {
+			records, next, err := log.ReadRange(tc.from, tc.to, tc.limit, api.IsolationLevel_READ_UNCOMMITTED)
+			require.NoError(t, err)
+			var got []string
+			for _, record := range records {
+				got = append(got, string(record.Value))
+			}
+			require.Equal(t, tc.want, got)
+			require.Equal(t, tc.next, next)
+		})
+	}
+
+	_, _, err = log.ReadRange(9, 0, 10, api.IsolationLevel_READ_UNCOMMITTED)
+	require.ErrorAs(t, err, &api.ErrOffsetOutOfRange{})
+}
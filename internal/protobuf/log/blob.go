@@ -0,0 +1,227 @@
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	blobDir           = "blobs"
+	blobFileExtention = ".blob"
+	blobSnapshotFile  = "blobs.snapshot"
+)
+
+// セグメントごとに参照している blob。どのセグメントからも参照されなくなった blob は削除する
+type blobRefs struct {
+	// スナップショットに反映済みの次のオフセット
+	NextOffset uint64                     `json:"next_offset"`
+	Segments   map[uint64]map[string]bool `json:"segments"`
+	// ディスク上の blob のサイズ。参照されていない書き込み直後の blob も含む
+	sizes map[string]uint64
+}
+
+func newBlobRefs() *blobRefs {
+	return &blobRefs{Segments: map[uint64]map[string]bool{}, sizes: map[string]uint64{}}
+}
+
+func (b *blobRefs) add(baseOffset uint64, ref string) {
+	refs, ok := b.Segments[baseOffset]
+	if !ok {
+		refs = map[string]bool{}
+		b.Segments[baseOffset] = refs
+	}
+	refs[ref] = true
+}
+
+func (b *blobRefs) referenced(ref string) bool {
+	for _, refs := range b.Segments {
+		if refs[ref] {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *blobRefs) bytes() uint64 {
+	var total uint64
+	for _, size := range b.sizes {
+		total += size
+	}
+	return total
+}
+
+func (b *blobRefs) save(fs FS, dir string, nextOffset uint64) error {
+	b.NextOffset = nextOffset
+	return saveSnapshot(fs, dir, blobSnapshotFile, b)
+}
+
+func (l *Log) checkRecordSize(record *api.Record) error {
+	max := l.conf.Record.MaxBytes
+	if max == 0 {
+		return nil
+	}
+	if size := api.RecordSize(record); size > max {
+		return api.ErrRecordTooLarge{Size: size, Max: max}
+	}
+	return nil
+}
+
+// 閾値を超える値を blob ファイルに書き出し、値の代わりに参照を持つレコードを返す。
+// 閾値以下の場合はそのままのレコードを返す
+func (l *Log) offload(record *api.Record) (*api.Record, error) {
+	threshold := l.conf.Record.OffloadThreshold
+	if threshold == 0 || uint64(len(record.Value)) <= threshold {
+		return record, nil
+	}
+	if err := l.checkStorageFor(uint64(len(record.Value))); err != nil {
+		return nil, err
+	}
+	ref, err := l.writeBlob(record.Value)
+	if err != nil {
+		return nil, err
+	}
+	value := record.Value
+	record.Value = nil
+	stored := proto.Clone(record).(*api.Record)
+	record.Value = value
+	stored.BlobRef = ref
+	return stored, nil
+}
+
+// 内容のハッシュをファイル名にするので、同じ値は1ファイルにまとまる
+func (l *Log) writeBlob(value []byte) (string, error) {
+	sum := sha256.Sum256(value)
+	ref := hex.EncodeToString(sum[:])
+	path := l.blobPath(ref)
+	if _, err := l.conf.fs().Stat(path); err == nil {
+		return ref, nil
+	}
+	if err := l.conf.fs().MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := l.conf.fs().WriteFile(tmp, value, 0600); err != nil {
+		return "", err
+	}
+	if err := l.conf.fs().Rename(tmp, path); err != nil {
+		return "", err
+	}
+	l.blobs.sizes[ref] = uint64(len(value))
+	return ref, nil
+}
+
+// blob を参照しているレコードの値を読み込む
+func (l *Log) resolveBlob(record *api.Record) error {
+	if record.BlobRef == "" {
+		return nil
+	}
+	value, err := l.conf.fs().ReadFile(l.blobPath(record.BlobRef))
+	if err != nil {
+		return fmt.Errorf("read blob of offset %d: %w", record.Offset, err)
+	}
+	record.Value = value
+	record.BlobRef = ""
+	return nil
+}
+
+func (l *Log) blobPath(ref string) string {
+	return filepath.Join(l.dir, blobDir, ref+blobFileExtention)
+}
+
+// 追記したレコードが参照する blob を、レコードのあるセグメントの参照として記録する
+func (l *Log) addBlobRef(record *api.Record) {
+	if record.BlobRef == "" {
+		return
+	}
+	if s := l.getSegmentIfContains(record.Offset); s != nil {
+		l.blobs.add(s.baseOffset, record.BlobRef)
+	}
+}
+
+// 削除したセグメントの参照を外し、どこからも参照されなくなった blob を削除する
+func (l *Log) removeBlobRefs(removed []*segment) error {
+	for _, s := range removed {
+		delete(l.blobs.Segments, s.baseOffset)
+	}
+	return l.removeUnreferencedBlobs()
+}
+
+// 途中まで切り捨てたセグメントの参照を、残ったレコードから作り直す
+func (l *Log) rebuildBlobRefs(s *segment) error {
+	delete(l.blobs.Segments, s.baseOffset)
+	for off := s.baseOffset; off < s.nextOffset; off++ {
+		record, err := s.Read(off)
+		if err != nil {
+			return err
+		}
+		l.addBlobRef(record)
+	}
+	return l.removeUnreferencedBlobs()
+}
+
+func (l *Log) removeUnreferencedBlobs() error {
+	for ref := range l.blobs.sizes {
+		if l.blobs.referenced(ref) {
+			continue
+		}
+		if err := l.conf.fs().Remove(l.blobPath(ref)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		delete(l.blobs.sizes, ref)
+	}
+	return nil
+}
+
+// スナップショット以降のレコードを読み直して blob の参照を復元し、
+// 追記に失敗した場合などに残った参照されていない blob を削除する
+func (l *Log) restoreBlobs() error {
+	b := newBlobRefs()
+	if _, err := loadSnapshot(l.conf.fs(), l.dir, blobSnapshotFile, b); err != nil {
+		return err
+	}
+	if b.Segments == nil || b.NextOffset > l.activeSegment.nextOffset {
+		// 切り捨て後のスナップショットがない場合は最初から読み直す
+		b = newBlobRefs()
+	}
+	bases := map[uint64]bool{}
+	for _, s := range l.segments {
+		bases[s.baseOffset] = true
+	}
+	for base := range b.Segments {
+		if !bases[base] {
+			delete(b.Segments, base)
+		}
+	}
+	l.blobs = b
+	if err := l.replay(b.NextOffset, l.addBlobRef); err != nil {
+		return err
+	}
+
+	files, err := l.conf.fs().ReadDir(filepath.Join(l.dir, blobDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), blobFileExtention) {
+			continue
+		}
+		ref := strings.TrimSuffix(file.Name(), blobFileExtention)
+		fi, err := l.conf.fs().Stat(l.blobPath(ref))
+		if err != nil {
+			return err
+		}
+		l.blobs.sizes[ref] = uint64(fi.Size())
+	}
+	return l.removeUnreferencedBlobs()
+}
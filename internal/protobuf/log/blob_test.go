@@ -0,0 +1,138 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobOffload(t *testing.T) {
+	dir, err := os.MkdirTemp("", "blob_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Segment.MaxStoreBytes = 1024
+	conf.Record.MaxBytes = 4096
+	conf.Record.OffloadThreshold = 64
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+
+	large := &api.Record{Value: bytes.Repeat([]byte("a"), 1024)}
+	small := &api.Record{Value: []byte("hello world")}
+
+	t.Run("large value is offloaded", func(t *testing.T) {
+		off, err := log.Append(large)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), large.Offset)
+		require.Len(t, large.Value, 1024)
+		require.Less(t, log.activeSegment.store.size, uint64(1024))
+
+		blobs, err := os.ReadDir(filepath.Join(dir, blobDir))
+		require.NoError(t, err)
+		require.Len(t, blobs, 1)
+
+		got, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, large.Value, got.Value)
+		require.Empty(t, got.BlobRef)
+	})
+
+	t.Run("same value shares a blob", func(t *testing.T) {
+		_, err := log.Append(&api.Record{Value: large.Value})
+		require.NoError(t, err)
+		blobs, err := os.ReadDir(filepath.Join(dir, blobDir))
+		require.NoError(t, err)
+		require.Len(t, blobs, 1)
+	})
+
+	t.Run("small value is stored inline", func(t *testing.T) {
+		off, err := log.Append(small)
+		require.NoError(t, err)
+		got, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, small.Value, got.Value)
+	})
+
+	t.Run("too large record fails", func(t *testing.T) {
+		_, err := log.Append(&api.Record{Value: bytes.Repeat([]byte("a"), 4096)})
+		require.ErrorAs(t, err, &api.ErrRecordTooLarge{})
+	})
+
+	t.Run("blob reference from client fails", func(t *testing.T) {
+		_, err := log.Append(&api.Record{BlobRef: "../../etc/passwd"})
+		require.ErrorAs(t, err, &api.ErrInvalidRecord{})
+	})
+}
+
+func TestBlobLifecycle(t *testing.T) {
+	dir, err := os.MkdirTemp("", "blob_lifecycle_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	// 1レコードごとにセグメントを切り替える
+	conf.Segment.MaxStoreBytes = 32
+	conf.Record.OffloadThreshold = 64
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+
+	now := time.Now()
+	expiresAt := now.Add(time.Hour).UnixMilli()
+	for _, c := range []byte("abcd") {
+		_, err := log.Append(&api.Record{Value: bytes.Repeat([]byte{c}, 1024), ExpiresAt: expiresAt})
+		require.NoError(t, err)
+	}
+	countBlobs := func() int {
+		blobs, err := os.ReadDir(filepath.Join(dir, blobDir))
+		require.NoError(t, err)
+		return len(blobs)
+	}
+	require.Equal(t, 4, countBlobs())
+
+	stats := log.Stats()
+	require.Equal(t, uint64(4*1024), stats.TotalBlobBytes)
+	require.Greater(t, stats.TotalDiskBytes, stats.TotalBlobBytes)
+	require.Equal(t, stats.TotalDiskBytes, log.diskBytes())
+
+	t.Run("truncate after removes blobs of dropped records", func(t *testing.T) {
+		require.NoError(t, log.TruncateAfter(2))
+		require.Equal(t, 3, countBlobs())
+		require.Equal(t, uint64(3*1024), log.Stats().TotalBlobBytes)
+	})
+
+	t.Run("truncate removes blobs of removed segments", func(t *testing.T) {
+		require.NoError(t, log.Truncate(0))
+		require.Equal(t, 2, countBlobs())
+		_, err := log.Read(0)
+		require.Error(t, err)
+		got, err := log.Read(1)
+		require.NoError(t, err)
+		require.Equal(t, bytes.Repeat([]byte("b"), 1024), got.Value)
+	})
+
+	t.Run("remove expired removes blobs of expired segments", func(t *testing.T) {
+		n, err := log.RemoveExpired(now.Add(2 * time.Hour))
+		require.NoError(t, err)
+		require.NotZero(t, n)
+		require.Equal(t, 1, countBlobs())
+		require.Equal(t, uint64(1024), log.Stats().TotalBlobBytes)
+	})
+
+	t.Run("references are restored after reopen", func(t *testing.T) {
+		require.NoError(t, log.Close())
+		log, err = NewLog(dir, conf)
+		require.NoError(t, err)
+		require.Equal(t, 1, countBlobs())
+		got, err := log.Read(2)
+		require.NoError(t, err)
+		require.Equal(t, bytes.Repeat([]byte("c"), 1024), got.Value)
+	})
+}
@@ -0,0 +1,168 @@
+package log
+
+import (
+	"container/list"
+	"sync"
+)
+
+// 開いている封印済みセグメントの LRU。上限を超えたら参照されていない古いものから閉じる。
+// 読み出しは Log の共有ロックで並行して行われるため、開閉はこのロックで排他する
+type segmentCache struct {
+	mu    sync.Mutex
+	max   int
+	lru   *list.List
+	elems map[*segment]*list.Element
+}
+
+func newSegmentCache(max int) *segmentCache {
+	return &segmentCache{
+		max:   max,
+		lru:   list.New(),
+		elems: map[*segment]*list.Element{},
+	}
+}
+
+// セグメントを開いて参照する。使い終わったら release を呼ぶ
+func (c *segmentCache) acquire(s *segment) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !s.isOpen() {
+		if err := s.reopen(); err != nil {
+			return err
+		}
+	}
+	s.refs++
+	c.touch(s)
+	return c.evict()
+}
+
+func (c *segmentCache) release(s *segment) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.refs--
+	return c.evict()
+}
+
+// 封印済みのセグメントのキー索引を引く。オフセットを破棄したセグメントは、
+// ブルームフィルタに該当した場合だけ開いて作り直す
+func (c *segmentCache) lookupKey(s *segment, key []byte) (uint64, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s.keys != nil && !s.keys.bloom.mayContain(key) {
+		return 0, false, nil
+	}
+	if !s.isOpen() {
+		if err := s.reopen(); err != nil {
+			return 0, false, err
+		}
+	}
+	c.touch(s)
+	off, ok := s.keys.lookup(key)
+	return off, ok, c.evict()
+}
+
+// 封印したセグメントを開いたまま加える
+func (c *segmentCache) add(s *segment) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.touch(s)
+	return c.evict()
+}
+
+func (c *segmentCache) remove(s *segment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.elems[s]; ok {
+		c.lru.Remove(e)
+		delete(c.elems, s)
+	}
+}
+
+// セグメントを開いた状態でキャッシュから外す。アクティブセグメントに戻す場合に使う
+func (c *segmentCache) take(s *segment) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.elems[s]; ok {
+		c.lru.Remove(e)
+		delete(c.elems, s)
+	}
+	if s.isOpen() {
+		return nil
+	}
+	return s.reopen()
+}
+
+func (c *segmentCache) setMax(max int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.max = max
+	return c.evict()
+}
+
+func (c *segmentCache) sizes(s *segment) (storeBytes, indexBytes, indexFileBytes uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return s.sizes()
+}
+
+// ストアの CRC-32 が記録済みのチェックサムと一致するか確認する。未記録なら記録する
+func (c *segmentCache) verify(s *segment, sum uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !s.checksumKnown {
+		s.checksum, s.checksumKnown = sum, true
+		return true
+	}
+	return s.checksum == sum
+}
+
+func (c *segmentCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *segmentCache) touch(s *segment) {
+	if e, ok := c.elems[s]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.elems[s] = c.lru.PushFront(s)
+}
+
+func (c *segmentCache) evict() error {
+	if c.max <= 0 {
+		return nil
+	}
+	for e := c.lru.Back(); e != nil && c.lru.Len() > c.max; {
+		prev := e.Prev()
+		s := e.Value.(*segment)
+		if s.refs == 0 {
+			if err := s.Close(); err != nil {
+				return err
+			}
+			c.lru.Remove(e)
+			delete(c.elems, s)
+		}
+		e = prev
+	}
+	return nil
+}
+
+// アクティブセグメントは常に開いているのでキャッシュで管理しない
+func (l *Log) acquire(s *segment) error {
+	if s == l.activeSegment {
+		return nil
+	}
+	return l.cache.acquire(s)
+}
+
+func (l *Log) release(s *segment) {
+	if s == l.activeSegment {
+		return
+	}
+	l.cache.release(s)
+}
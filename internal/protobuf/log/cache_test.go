@@ -0,0 +1,80 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func openSegments(l *Log) int {
+	n := 0
+	for _, s := range l.segments {
+		if s.isOpen() {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLazySegments(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cache_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Segment.MaxStoreBytes = 10
+	conf.Segment.MaxIndexBytes = 1024
+	conf.SegmentCache.MaxOpenSegments = 2
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	const records = 10
+	for i := 0; i < records; i++ {
+		_, err = log.Append(&api.Record{Value: []byte(fmt.Sprintf("%d", i))})
+		require.NoError(t, err)
+	}
+	require.Equal(t, records, len(log.segments))
+	// ロールしたセグメントも上限までしか開いておかない
+	require.LessOrEqual(t, openSegments(log), conf.SegmentCache.MaxOpenSegments+1)
+	before := log.Stats()
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// 起動時に開くのはアクティブセグメントのみ
+	require.Equal(t, 1, openSegments(log))
+	require.Equal(t, uint64(records-1), log.HighestOffset())
+	after := log.Stats()
+	require.Equal(t, before.TotalStoreBytes, after.TotalStoreBytes)
+	require.Equal(t, before.TotalIndexBytes, after.TotalIndexBytes)
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				record, err := log.Read(uint64(i))
+				require.NoError(t, err)
+				require.Equal(t, []byte(fmt.Sprintf("%d", i)), record.Value)
+			}
+		}()
+	}
+	wg.Wait()
+	require.LessOrEqual(t, log.cache.len(), conf.SegmentCache.MaxOpenSegments)
+	require.LessOrEqual(t, openSegments(log), conf.SegmentCache.MaxOpenSegments+1)
+
+	b, err := io.ReadAll(log.Reader())
+	require.NoError(t, err)
+	require.Equal(t, int(after.TotalStoreBytes), len(b))
+
+	conf.SegmentCache.MaxOpenSegments = 1
+	require.NoError(t, log.UpdateConfig(conf))
+	require.LessOrEqual(t, openSegments(log), 2)
+}
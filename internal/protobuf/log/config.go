@@ -1,9 +1,65 @@
 package log
 
+import (
+	"fmt"
+	"time"
+)
+
 type Config struct {
+	// nil の場合は OS のファイルシステムを使う
+	FS      FS
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
 	}
+	Record struct {
+		// 1レコードの最大サイズ (0: 無制限)
+		MaxBytes uint64
+		// 値がこれより大きいレコードは値を blob ファイルに書き出す (0: 書き出さない)
+		OffloadThreshold uint64
+	}
+	Storage struct {
+		// データ領域の空き容量がこれを下回る追記は拒否する (0: チェックしない)
+		MinFreeBytes uint64
+		// ログ全体のディスク使用量の上限 (0: 無制限)
+		MaxLogBytes uint64
+	}
+	Manifest struct {
+		// MANIFEST を使わずにディレクトリを走査してセグメントを復元し、MANIFEST を作り直す
+		Rebuild bool
+	}
+	SegmentCache struct {
+		// 同時に開いておく封印済みセグメントの最大数 (0: 無制限)。封印済みのセグメントは読み出すまで開かない
+		MaxOpenSegments int
+	}
+	KeyIndex struct {
+		// レコードのキーをセグメントごとに索引し、キーごとの最新のレコードを読めるようにする
+		Enabled bool
+	}
+	Retention struct {
+		// 全レコードが期限切れになったセグメントを削除する間隔 (0: 自動では削除しない)
+		ExpiryCheckInterval time.Duration
+	}
+	Transaction struct {
+		// この時間更新のないトランザクションはアボートする
+		Timeout time.Duration
+	}
+}
+
+func (c Config) validate() error {
+	if c.Segment.MaxStoreBytes == 0 {
+		return fmt.Errorf("invalid config: MaxStoreBytes must be greater than 0")
+	}
+	if c.Segment.MaxIndexBytes < entryWidth {
+		return fmt.Errorf("invalid config: MaxIndexBytes must be at least %d", entryWidth)
+	}
+	return nil
+}
+
+func (c Config) fs() FS {
+	if c.FS == nil {
+		return OSFS{}
+	}
+	return c.FS
 }
@@ -0,0 +1,70 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	dir, err := os.MkdirTemp("", "config_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Segment.MaxStoreBytes = 1024
+	conf.Segment.MaxIndexBytes = entryWidth * 2
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+
+	t.Run("invalid config", func(t *testing.T) {
+		invalid := log.Config()
+		invalid.Segment.MaxIndexBytes = entryWidth - 1
+		require.Error(t, log.UpdateConfig(invalid))
+		invalid = log.Config()
+		invalid.Segment.MaxStoreBytes = 0
+		require.Error(t, log.UpdateConfig(invalid))
+		invalid = log.Config()
+		invalid.Segment.InitialOffset = 10
+		require.Error(t, log.UpdateConfig(invalid))
+	})
+
+	record := &api.Record{Value: []byte("hello world")}
+	_, err = log.Append(record)
+	require.NoError(t, err)
+
+	updated := log.Config()
+	updated.Segment.MaxIndexBytes = entryWidth * 4
+	require.NoError(t, log.UpdateConfig(updated))
+
+	t.Run("applied from next segment", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err = log.Append(record)
+			require.NoError(t, err)
+		}
+		require.Len(t, log.segments, 2)
+		require.Equal(t, entryWidth*2, log.segments[0].config.Segment.MaxIndexBytes)
+		require.Equal(t, entryWidth*4, log.segments[1].config.Segment.MaxIndexBytes)
+	})
+
+	require.NoError(t, log.Close())
+
+	t.Run("reopen with smaller limits keeps sealed segments", func(t *testing.T) {
+		smaller := Config{}
+		smaller.Segment.MaxStoreBytes = 1024
+		smaller.Segment.MaxIndexBytes = entryWidth
+		restored, err := NewLog(dir, smaller)
+		require.NoError(t, err)
+		defer restored.Close()
+
+		require.Equal(t, entryWidth*4, restored.activeSegment.config.Segment.MaxIndexBytes)
+		require.Equal(t, uint64(3), restored.HighestOffset())
+		for off := uint64(0); off <= 3; off++ {
+			got, err := restored.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, record.Value, got.Value)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package log
+
+import (
+	"math"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+)
+
+// 有効期限のないレコードを含むセグメントの有効期限
+const neverExpires = math.MaxInt64
+
+// TTL だけ指定されたレコードに追記時刻からの有効期限を設定する
+func setExpiry(record *api.Record, now time.Time) {
+	if record.TtlMs > 0 && record.ExpiresAt == 0 {
+		record.ExpiresAt = now.Add(time.Duration(record.TtlMs) * time.Millisecond).UnixMilli()
+	}
+}
+
+func isExpired(record *api.Record, now time.Time) bool {
+	return record.ExpiresAt != 0 && record.ExpiresAt <= now.UnixMilli()
+}
+
+func expiresAt(record *api.Record) int64 {
+	if record.ExpiresAt == 0 {
+		return neverExpires
+	}
+	return record.ExpiresAt
+}
+
+func (s *segment) updateExpiry(record *api.Record) {
+	if !s.expiryKnown {
+		return
+	}
+	if e := expiresAt(record); e > s.maxExpiresAt {
+		s.maxExpiresAt = e
+	}
+}
+
+// セグメント内で最も遅い有効期限を返す。開き直したセグメントは初回に全レコードを読んで求める
+func (s *segment) latestExpiry() (int64, error) {
+	if s.expiryKnown {
+		return s.maxExpiresAt, nil
+	}
+	var latest int64
+	for off := s.baseOffset; off < s.nextOffset; off++ {
+		record, err := s.Read(off)
+		if err != nil {
+			return 0, err
+		}
+		if e := expiresAt(record); e > latest {
+			latest = e
+		}
+	}
+	s.maxExpiresAt = latest
+	s.expiryKnown = true
+	return latest, nil
+}
+
+// 全レコードが期限切れになった古いセグメントを削除し、削除したセグメント数を返す。
+// 先頭から連続するセグメントのみ対象とし、アクティブセグメントは削除しない
+func (l *Log) RemoveExpired(now time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.removeExpired(now)
+}
+
+func (l *Log) removeExpired(now time.Time) (int, error) {
+	n := 0
+	for n < len(l.segments)-1 {
+		s := l.segments[n]
+		if err := l.acquire(s); err != nil {
+			return 0, err
+		}
+		latest, err := s.latestExpiry()
+		l.release(s)
+		if err != nil {
+			return 0, err
+		}
+		if latest > now.UnixMilli() {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	removed := l.segments[:n]
+	l.segments = l.segments[n:]
+	if err := l.saveManifest(); err != nil {
+		return 0, err
+	}
+	for _, s := range removed {
+		if err := s.Remove(); err != nil {
+			return 0, err
+		}
+		l.cache.remove(s)
+	}
+	if err := l.removeBlobRefs(removed); err != nil {
+		return 0, err
+	}
+	l.storage.checkedAt = time.Time{}
+	return n, nil
+}
+
+func (l *Log) startExpiryReaper() {
+	interval := l.conf.Retention.ExpiryCheckInterval
+	if interval == 0 {
+		return
+	}
+	stop := l.stopReaper
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				l.mu.Lock()
+				select {
+				case <-stop: // Close 済み
+				default:
+					l.removeExpired(now)
+				}
+				l.mu.Unlock()
+			}
+		}
+	}()
+}
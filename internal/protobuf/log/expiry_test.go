@@ -0,0 +1,87 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiry(t *testing.T) {
+	log := newTestLog(t, 1024, 0)
+	past := time.Now().Add(-time.Minute).UnixMilli()
+
+	expired, err := log.Append(&api.Record{Value: []byte("expired"), ExpiresAt: past})
+	require.NoError(t, err)
+	_, err = log.Read(expired)
+	require.ErrorAs(t, err, &api.ErrRecordExpired{})
+	_, err = log.ReadCommitted(expired)
+	require.ErrorAs(t, err, &api.ErrRecordExpired{})
+
+	// TTL から有効期限を算出する
+	record := &api.Record{Value: []byte("ttl"), TtlMs: 50}
+	off, err := log.Append(record)
+	require.NoError(t, err)
+	require.NotZero(t, record.ExpiresAt)
+	read, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, record.ExpiresAt, read.ExpiresAt)
+	require.Eventually(t, func() bool {
+		_, err := log.Read(off)
+		_, ok := err.(api.ErrRecordExpired)
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	off, err = log.Append(&api.Record{Value: []byte("forever")})
+	require.NoError(t, err)
+	_, err = log.Read(off)
+	require.NoError(t, err)
+
+	// 期限切れのレコードも書き出す
+	var buf bytes.Buffer
+	n, err := log.Export(&buf, FormatDelimitedProto)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+}
+
+func TestRemoveExpired(t *testing.T) {
+	dir, err := os.MkdirTemp("", "expiry_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Segment.MaxStoreBytes = 10
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	past := time.Now().Add(-time.Minute).UnixMilli()
+	for _, record := range []*api.Record{
+		{Value: []byte("a"), ExpiresAt: past},
+		{Value: []byte("b"), ExpiresAt: past},
+		{Value: []byte("c")},
+		{Value: []byte("d"), ExpiresAt: past},
+		{Value: []byte("e")},
+	} {
+		_, err = log.Append(record)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 5, len(log.segments))
+
+	// 開き直したセグメントはレコードを読んで有効期限を求める
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+
+	removed, err := log.RemoveExpired(time.Now())
+	require.NoError(t, err)
+	// 期限のないレコードを含むセグメント以降は残す
+	require.Equal(t, 2, removed)
+	require.Equal(t, uint64(2), log.LowestOffset())
+
+	removed, err = log.RemoveExpired(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 0, removed)
+}
@@ -0,0 +1,198 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type Format int
+
+const (
+	FormatJSONLines      Format = iota // 1行1レコードのJSON (NDJSON)
+	FormatDelimitedProto               // varint長プレフィックス付きのprotobuf
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "jsonl", "ndjson", "json":
+		return FormatJSONLines, nil
+	case "proto", "protobuf", "pb":
+		return FormatDelimitedProto, nil
+	}
+	return 0, fmt.Errorf("unknown format: %q", s)
+}
+
+type ImportOptions struct {
+	// true: レコードのオフセットをそのまま使う。false: 末尾から採番し直す
+	PreserveOffsets bool
+}
+
+// 最古から最新までのレコードを順に書き出す。書き出した件数を返す
+func (l *Log) Export(w io.Writer, format Format) (int, error) {
+	bw := bufio.NewWriter(w)
+	count := 0
+	for off := l.LowestOffset(); ; off++ {
+		record, err := l.readForExport(off)
+		if errors.As(err, &api.ErrOffsetOutOfRange{}) {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		if err = writeRecord(bw, record, format); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, bw.Flush()
+}
+
+// 期限切れのレコードも含めて読み出す。インポート時にオフセットを保持できるように
+func (l *Log) readForExport(offset uint64) (*api.Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.read(offset)
+}
+
+// Export で書き出したレコードを読み込んで追記する。追記した件数を返す
+func (l *Log) Import(r io.Reader, format Format, opts ImportOptions) (int, error) {
+	br := bufio.NewReader(r)
+	count := 0
+	for {
+		record, err := readRecord(br, format)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if opts.PreserveOffsets {
+			if err = l.prepareOffset(record.Offset); err != nil {
+				return count, err
+			}
+		}
+		want := record.Offset
+		off, err := l.importRecord(record)
+		if err != nil {
+			return count, err
+		}
+		if opts.PreserveOffsets && off != want {
+			return count, fmt.Errorf("offset mismatch: want %d, got %d", want, off)
+		}
+		count++
+	}
+}
+
+// レコードをそのまま追記する。Append と違い制御レコードやトランザクションのレコードも受け付け、
+// プロデューサーとトランザクションの状態は追記したレコードから組み立て直す
+func (l *Log) importRecord(record *api.Record) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if record.BlobRef != "" {
+		return 0, fmt.Errorf("blob reference cannot be imported: %s", record.BlobRef)
+	}
+	now := time.Now()
+	setExpiry(record, now)
+	setTimestamp(record, now, l.lastTimestamp)
+	if err := l.checkRecordSize(record); err != nil {
+		return 0, err
+	}
+	stored, err := l.offload(record)
+	if err != nil {
+		return 0, err
+	}
+	return l.append(stored)
+}
+
+// オフセット保持でのインポート時、空のログであれば先頭オフセットをレコードに合わせる
+func (l *Log) prepareOffset(offset uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	next := l.activeSegment.nextOffset
+	if next == offset {
+		return nil
+	}
+	if len(l.segments) != 1 || next != l.activeSegment.baseOffset {
+		return fmt.Errorf("offset %d is not contiguous: next offset is %d", offset, next)
+	}
+	if err := l.activeSegment.Remove(); err != nil {
+		return err
+	}
+	l.segments = nil
+	l.activeSegment = nil
+	return l.newSegment(offset)
+}
+
+func writeRecord(w io.Writer, record *api.Record, format Format) error {
+	switch format {
+	case FormatJSONLines:
+		b, err := protojson.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		return nil
+	case FormatDelimitedProto:
+		b, err := proto.Marshal(record)
+		if err != nil {
+			return err
+		}
+		size := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(size, uint64(len(b)))
+		if _, err = w.Write(size[:n]); err != nil {
+			return err
+		}
+		if _, err = w.Write(b); err != nil {
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format: %d", format)
+}
+
+func readRecord(r *bufio.Reader, format Format) (*api.Record, error) {
+	record := &api.Record{}
+	switch format {
+	case FormatJSONLines:
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(line) == 0 && err != nil {
+				return nil, err
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue // 空行は読み飛ばす
+			}
+			if err := protojson.Unmarshal(line, record); err != nil {
+				return nil, err
+			}
+			return record, nil
+		}
+	case FormatDelimitedProto:
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if err = proto.Unmarshal(b, record); err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+	return nil, fmt.Errorf("unknown format: %d", format)
+}
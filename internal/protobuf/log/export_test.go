@@ -0,0 +1,122 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	for senario, format := range map[string]Format{
+		"json lines":      FormatJSONLines,
+		"delimited proto": FormatDelimitedProto,
+	} {
+		t.Run(senario, func(t *testing.T) {
+			src := newTestLog(t, 32, 5)
+			values := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+			for _, v := range values {
+				_, err := src.Append(&api.Record{Value: v})
+				require.NoError(t, err)
+			}
+
+			var buf bytes.Buffer
+			n, err := src.Export(&buf, format)
+			require.NoError(t, err)
+			require.Equal(t, len(values), n)
+
+			t.Run("preserve offsets", func(t *testing.T) {
+				dst := newTestLog(t, 1024, 0)
+				n, err := dst.Import(bytes.NewReader(buf.Bytes()), format, ImportOptions{PreserveOffsets: true})
+				require.NoError(t, err)
+				require.Equal(t, len(values), n)
+				require.Equal(t, uint64(5), dst.LowestOffset())
+				for i, v := range values {
+					record, err := dst.Read(uint64(5 + i))
+					require.NoError(t, err)
+					require.Equal(t, v, record.Value)
+				}
+			})
+
+			t.Run("resequence", func(t *testing.T) {
+				dst := newTestLog(t, 1024, 0)
+				n, err := dst.Import(bytes.NewReader(buf.Bytes()), format, ImportOptions{})
+				require.NoError(t, err)
+				require.Equal(t, len(values), n)
+				for i, v := range values {
+					record, err := dst.Read(uint64(i))
+					require.NoError(t, err)
+					require.Equal(t, v, record.Value)
+				}
+			})
+
+			t.Run("preserve offsets into non-empty log fails", func(t *testing.T) {
+				dst := newTestLog(t, 1024, 0)
+				_, err := dst.Append(&api.Record{Value: []byte("existing")})
+				require.NoError(t, err)
+				_, err = dst.Import(bytes.NewReader(buf.Bytes()), format, ImportOptions{PreserveOffsets: true})
+				require.Error(t, err)
+			})
+		})
+	}
+}
+
+func TestExportImportTransactions(t *testing.T) {
+	src := newTestLog(t, 1024, 0)
+	require.NoError(t, src.BeginTransaction("committed"))
+	require.NoError(t, src.BeginTransaction("aborted"))
+	for _, r := range []*api.Record{
+		{Value: []byte("c1"), TransactionId: "committed"},
+		{Value: []byte("a1"), TransactionId: "aborted"},
+		{Value: []byte("plain")},
+	} {
+		_, err := src.Append(r)
+		require.NoError(t, err)
+	}
+	_, err := src.CommitTransaction("committed")
+	require.NoError(t, err)
+	_, err = src.AbortTransaction("aborted")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := src.Export(&buf, FormatDelimitedProto)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	dst := newTestLog(t, 1024, 0)
+	n, err = dst.Import(bytes.NewReader(buf.Bytes()), FormatDelimitedProto, ImportOptions{PreserveOffsets: true})
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	require.Empty(t, dst.txns.Open)
+	require.Equal(t, uint64(5), dst.LastStableOffset())
+
+	record, err := dst.ReadCommitted(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("c1"), record.Value)
+	_, err = dst.ReadCommitted(1)
+	require.ErrorAs(t, err, &api.ErrRecordFiltered{})
+	record, err = dst.ReadCommitted(2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("plain"), record.Value)
+	for _, off := range []uint64{3, 4} {
+		_, err = dst.ReadCommitted(off)
+		require.ErrorAs(t, err, &api.ErrRecordFiltered{})
+	}
+}
+
+func newTestLog(t *testing.T, maxStoreBytes, initialOffset uint64) *Log {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "log_test")
+	require.NoError(t, err)
+	conf := Config{}
+	conf.Segment.MaxStoreBytes = maxStoreBytes
+	conf.Segment.InitialOffset = initialOffset
+	l, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, l.Remove())
+	})
+	return l
+}
@@ -0,0 +1,204 @@
+package log
+
+import (
+	"errors"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// Crash より前に開いたファイルを使った
+var ErrCrashed = errors.New("file was opened before the simulated crash")
+
+// 障害を注入するファイルシステム。書き込みの失敗・遅延と、同期していない書き込みを失うクラッシュを再現する
+type FaultFS struct {
+	fs FS
+
+	mu         sync.Mutex
+	writeFault func(name string) error
+	latency    time.Duration
+	generation int
+}
+
+var _ FS = (*FaultFS)(nil)
+
+func NewFaultFS(fs FS) *FaultFS {
+	return &FaultFS{fs: fs}
+}
+
+// 書き込み (作成・削除・リネーム・同期を含む) の前に呼ばれ、エラーを返すとその書き込みを失敗させる。nil で解除する
+func (f *FaultFS) SetWriteFault(fn func(name string) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeFault = fn
+}
+
+// 書き込みと同期のたびに待つ時間
+func (f *FaultFS) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = d
+}
+
+// 同期していない書き込みを捨てる。内側のファイルシステムが Crash を実装している必要がある
+func (f *FaultFS) Crash() error {
+	c, ok := f.fs.(interface{ Crash() })
+	if !ok {
+		return errors.New("inner file system cannot simulate a crash")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c.Crash()
+	f.generation++
+	return nil
+}
+
+func (f *FaultFS) beforeWrite(name string) error {
+	f.mu.Lock()
+	fault, latency := f.writeFault, f.latency
+	f.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if fault != nil {
+		return fault(name)
+	}
+	return nil
+}
+
+func (f *FaultFS) currentGeneration() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.generation
+}
+
+func (f *FaultFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		if err := f.beforeWrite(name); err != nil {
+			return nil, err
+		}
+	}
+	file, err := f.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f, generation: f.currentGeneration()}, nil
+}
+
+func (f *FaultFS) Stat(name string) (os.FileInfo, error) {
+	return f.fs.Stat(name)
+}
+
+func (f *FaultFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return f.fs.ReadDir(name)
+}
+
+func (f *FaultFS) MkdirAll(path string, perm os.FileMode) error {
+	if err := f.beforeWrite(path); err != nil {
+		return err
+	}
+	return f.fs.MkdirAll(path, perm)
+}
+
+func (f *FaultFS) Remove(name string) error {
+	if err := f.beforeWrite(name); err != nil {
+		return err
+	}
+	return f.fs.Remove(name)
+}
+
+func (f *FaultFS) RemoveAll(path string) error {
+	if err := f.beforeWrite(path); err != nil {
+		return err
+	}
+	return f.fs.RemoveAll(path)
+}
+
+func (f *FaultFS) Rename(oldpath, newpath string) error {
+	if err := f.beforeWrite(newpath); err != nil {
+		return err
+	}
+	return f.fs.Rename(oldpath, newpath)
+}
+
+func (f *FaultFS) ReadFile(name string) ([]byte, error) {
+	return f.fs.ReadFile(name)
+}
+
+func (f *FaultFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	if err := f.beforeWrite(name); err != nil {
+		return err
+	}
+	return f.fs.WriteFile(name, data, perm)
+}
+
+func (f *FaultFS) FreeBytes(dir string) (uint64, error) {
+	r, ok := f.fs.(freeSpaceReporter)
+	if !ok {
+		return math.MaxUint64, nil
+	}
+	return r.FreeBytes(dir)
+}
+
+type faultFile struct {
+	File
+	fs         *FaultFS
+	generation int
+}
+
+func (f *faultFile) check() error {
+	if f.generation != f.fs.currentGeneration() {
+		return ErrCrashed
+	}
+	return nil
+}
+
+func (f *faultFile) beforeWrite() error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	return f.fs.beforeWrite(f.Name())
+}
+
+func (f *faultFile) Read(p []byte) (int, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *faultFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.check(); err != nil {
+		return 0, err
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	if err := f.beforeWrite(); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.beforeWrite(); err != nil {
+		return 0, err
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.beforeWrite(); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if err := f.beforeWrite(); err != nil {
+		return err
+	}
+	return f.File.Truncate(size)
+}
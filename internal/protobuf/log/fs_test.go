@@ -0,0 +1,141 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func newMemConfig(fs FS) Config {
+	conf := Config{FS: fs}
+	conf.Segment.MaxStoreBytes = 32
+	conf.Segment.MaxIndexBytes = 1024
+	return conf
+}
+
+func appendValues(t *testing.T, log *Log, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		off, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("%d", i))})
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), off)
+	}
+}
+
+func requireValues(t *testing.T, log *Log, n int) {
+	t.Helper()
+	require.Equal(t, uint64(n-1), log.HighestOffset())
+	for i := 0; i < n; i++ {
+		record, err := log.Read(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("%d", i)), record.Value)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	fs := NewMemFS()
+	require.NoError(t, fs.MkdirAll("/log", 0700))
+	conf := newMemConfig(fs)
+
+	log, err := NewLog("/log", conf)
+	require.NoError(t, err)
+	appendValues(t, log, 0, 10)
+	require.Greater(t, len(log.segments), 1)
+	require.NoError(t, log.Close())
+
+	log, err = NewLog("/log", conf)
+	require.NoError(t, err)
+	requireValues(t, log, 10)
+	require.NoError(t, log.Verify())
+	require.NoError(t, log.Remove())
+	_, err = fs.Stat("/log")
+	require.Error(t, err)
+}
+
+func TestFaultFS(t *testing.T) {
+	for senario, fn := range map[string]func(*testing.T, *FaultFS, Config){
+		"crash drops unsynced records": testFaultCrash,
+		"crash without flush":          testFaultCrashWithoutFlush,
+		"failed write is reported":     testFaultWrite,
+		"latency is injected":          testFaultLatency,
+	} {
+		t.Run(senario, func(t *testing.T) {
+			fs := NewFaultFS(NewMemFS())
+			require.NoError(t, fs.MkdirAll("/log", 0700))
+			fn(t, fs, newMemConfig(fs))
+		})
+	}
+}
+
+func testFaultCrash(t *testing.T, fs *FaultFS, conf Config) {
+	log, err := NewLog("/log", conf)
+	require.NoError(t, err)
+	appendValues(t, log, 0, 10)
+	require.NoError(t, log.Flush())
+	appendValues(t, log, 10, 12)
+
+	require.NoError(t, fs.Crash())
+	// クラッシュ前に開いたファイルは使えない
+	_, err = log.activeSegment.store.ReadAt(make([]byte, 1), 0)
+	require.ErrorIs(t, err, ErrCrashed)
+
+	log, err = NewLog("/log", conf)
+	require.NoError(t, err)
+	defer log.Close()
+	requireValues(t, log, 10)
+	// 失われたオフセットから追記を続けられる
+	appendValues(t, log, 10, 12)
+	requireValues(t, log, 12)
+}
+
+func testFaultCrashWithoutFlush(t *testing.T, fs *FaultFS, conf Config) {
+	log, err := NewLog("/log", conf)
+	require.NoError(t, err)
+	appendValues(t, log, 0, 10)
+	require.Greater(t, len(log.segments), 3)
+	sealed := log.activeSegment.baseOffset
+
+	require.NoError(t, fs.Crash())
+
+	log, err = NewLog("/log", conf)
+	require.NoError(t, err)
+	defer log.Close()
+	// 封印済みのセグメントはロール時に同期するので残り、先頭から欠けずに読める
+	n := int(log.HighestOffset()) + 1
+	require.GreaterOrEqual(t, n, int(sealed))
+	requireValues(t, log, n)
+	appendValues(t, log, n, 12)
+	requireValues(t, log, 12)
+}
+
+func testFaultWrite(t *testing.T, fs *FaultFS, conf Config) {
+	conf.Segment.MaxStoreBytes = 1024
+	log, err := NewLog("/log", conf)
+	require.NoError(t, err)
+	defer log.Close()
+	appendValues(t, log, 0, 3)
+
+	injected := errors.New("disk failure")
+	fs.SetWriteFault(func(string) error { return injected })
+	_, err = log.Append(&api.Record{Value: []byte("value")})
+	require.NoError(t, err) // バッファに書くだけなので同期まで失敗しない
+	require.ErrorIs(t, log.Flush(), injected)
+
+	fs.SetWriteFault(nil)
+	require.NoError(t, log.Flush())
+}
+
+func testFaultLatency(t *testing.T, fs *FaultFS, conf Config) {
+	log, err := NewLog("/log", conf)
+	require.NoError(t, err)
+	defer log.Close()
+
+	fs.SetLatency(20 * time.Millisecond)
+	start := time.Now()
+	require.NoError(t, log.Flush())
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
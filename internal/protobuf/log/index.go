@@ -15,33 +15,74 @@ const (
 )
 
 type index struct {
-	file *os.File
+	file File
 	mmap gommap.MMap
 	size uint64
+	// OS のファイル以外は mmap できないので、メモリ上に読み込んで Flush で書き戻す
+	mapped bool
 }
 
-func newIndex(f *os.File, c Config) (*index, error) {
+func newIndex(f File, c Config) (*index, error) {
 	idx := &index{file: f}
-	fi, err := os.Stat(f.Name())
+	fi, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
 	idx.size = uint64(fi.Size())
 
-	if err = os.Truncate(f.Name(), int64(c.Segment.MaxIndexBytes)); err != nil {
-		return nil, err
+	// 書き込み済みのエントリは切り詰めない
+	fileSize := idx.size
+	if fileSize < c.Segment.MaxIndexBytes {
+		fileSize = c.Segment.MaxIndexBytes
+		if err = f.Truncate(int64(fileSize)); err != nil {
+			return nil, err
+		}
 	}
 
+	osFile, ok := f.(*os.File)
+	if !ok {
+		idx.mmap = make(gommap.MMap, fileSize)
+		if _, err = f.ReadAt(idx.mmap, 0); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return idx, nil
+	}
 	if idx.mmap, err = gommap.Map(
-		idx.file.Fd(),
+		osFile.Fd(),
 		gommap.PROT_READ|gommap.PROT_WRITE,
 		gommap.MAP_SHARED,
 	); err != nil {
 		return nil, err
 	}
+	idx.mapped = true
 	return idx, nil
 }
 
+// 閉じずに終了したインデックスは MaxIndexBytes まで 0 埋めされたままなので、
+// 相対オフセットが連番で、ストアに書き込み済みの位置を指すエントリまでを有効とする
+func (i *index) recover(storeSize uint64) {
+	var n uint64
+	for ; (n+1)*entryWidth <= i.size; n++ {
+		off, pos, _ := i.readEntry(uint32(n))
+		if uint64(off) != n || pos+lenWidth > storeSize {
+			break
+		}
+	}
+	i.size = n * entryWidth
+}
+
+// 先頭から n 件のエントリを残す。残りは 0 埋めして復旧時に有効と見なさないようにする
+func (i *index) truncate(n uint64) {
+	size := n * entryWidth
+	if size >= i.size {
+		return
+	}
+	for j := size; j < i.size; j++ {
+		i.mmap[j] = 0
+	}
+	i.size = size
+}
+
 func (i *index) Name() string {
 	return i.file.Name()
 }
@@ -96,7 +137,11 @@ func (i *index) empty() bool {
 }
 
 func (i *index) Flush() error {
-	if err := i.mmap.Sync(gommap.MS_ASYNC); err != nil {
+	if !i.mapped {
+		if _, err := i.file.WriteAt(i.mmap[:i.size], 0); err != nil {
+			return fmt.Errorf("index write error: %w", err)
+		}
+	} else if err := i.mmap.Sync(gommap.MS_ASYNC); err != nil {
 		return fmt.Errorf("mmap sync error: %v", err)
 	}
 	if err := i.file.Sync(); err != nil {
@@ -111,8 +156,10 @@ func (i *index) Close() error {
 	}
 	// メモリ解放しないと file.Truncate() で以下エラーが発生する（Windows 特有の事象かもしれない…）
 	// The requested operation cannot be performed on a file with a user-mapped section open.
-	if err := i.mmap.UnsafeUnmap(); err != nil {
-		return fmt.Errorf("mmmap unmap error: %v", err)
+	if i.mapped {
+		if err := i.mmap.UnsafeUnmap(); err != nil {
+			return fmt.Errorf("mmmap unmap error: %v", err)
+		}
 	}
 
 	if err := i.file.Truncate(int64(i.size)); err != nil {
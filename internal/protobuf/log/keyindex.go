@@ -0,0 +1,178 @@
+package log
+
+import (
+	"bytes"
+	"hash/fnv"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+)
+
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+// セグメント内のキーごとの最新オフセット。
+// ブルームフィルタでキーを含まないセグメントを飛ばす
+type keyIndex struct {
+	// セグメントを閉じたら破棄し、ブルームフィルタだけ残す
+	offsets map[string]uint64
+	bloom   *bloomFilter
+}
+
+func newKeyIndex(config Config) *keyIndex {
+	// インデックスに書けるレコード数を想定するキー数とする
+	return &keyIndex{
+		offsets: map[string]uint64{},
+		bloom:   newBloomFilter(config.Segment.MaxIndexBytes / entryWidth),
+	}
+}
+
+func (k *keyIndex) add(key []byte, offset uint64) {
+	k.offsets[string(key)] = offset
+	k.bloom.add(key)
+}
+
+func (k *keyIndex) lookup(key []byte) (uint64, bool) {
+	if !k.bloom.mayContain(key) {
+		return 0, false
+	}
+	off, ok := k.offsets[string(key)]
+	return off, ok
+}
+
+type bloomFilter struct {
+	bits []uint64
+	size uint64
+}
+
+func newBloomFilter(keys uint64) *bloomFilter {
+	size := keys * bloomBitsPerKey
+	if size < 64 {
+		size = 64
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (size+63)/64),
+		size: size,
+	}
+}
+
+// 1つのハッシュ値を2つに分けて、k 個のハッシュ関数の代わりにする
+func (b *bloomFilter) positions(key []byte) [bloomHashes]uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	var pos [bloomHashes]uint64
+	for i := range pos {
+		pos[i] = (h1 + uint64(i)*h2) % b.size
+	}
+	return pos
+}
+
+func (b *bloomFilter) add(key []byte) {
+	for _, p := range b.positions(key) {
+		b.bits[p/64] |= 1 << (p % 64)
+	}
+}
+
+func (b *bloomFilter) mayContain(key []byte) bool {
+	for _, p := range b.positions(key) {
+		if b.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 開き直したセグメントのキー索引をレコードを読んで作り直す。作成済みの場合は何もしない
+func (s *segment) buildKeyIndex() error {
+	if !s.config.KeyIndex.Enabled || (s.keys != nil && s.keys.offsets != nil) {
+		return nil
+	}
+	keys := newKeyIndex(s.config)
+	for off := s.baseOffset; off < s.nextOffset; off++ {
+		record, err := s.Read(off)
+		if err != nil {
+			return err
+		}
+		if len(record.Key) > 0 {
+			keys.add(record.Key, off)
+		}
+	}
+	s.keys = keys
+	return nil
+}
+
+// キーを持つ最新のレコードを返す。新しいセグメントから順に探す。
+// read_committed では LSO 以降のレコードとアボート済みのレコードを飛ばす
+func (l *Log) ReadLatestByKey(key []byte, isolation api.IsolationLevel) (*api.Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if !l.conf.KeyIndex.Enabled {
+		return nil, api.ErrKeyIndexDisabled{}
+	}
+	end := l.activeSegment.nextOffset
+	committed := isolation == api.IsolationLevel_READ_COMMITTED
+	if committed {
+		end = l.txns.lastStableOffset(end)
+	}
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		s := l.segments[i]
+		if s.baseOffset >= end {
+			continue
+		}
+		off, ok, err := l.lookupKey(s, key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		record, ok, err := l.latestInSegment(s, key, off, end, committed)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if isExpired(record, time.Now()) {
+			return nil, api.ErrRecordExpired{Offset: record.Offset}
+		}
+		return record, nil
+	}
+	return nil, api.ErrKeyNotFound{Key: key}
+}
+
+// 索引にあるのはセグメント内の最新のオフセットだけなので、
+// それが読めないレコードの場合はセグメントを遡ってキーを持つレコードを探す
+func (l *Log) latestInSegment(s *segment, key []byte, off, end uint64, committed bool) (*api.Record, bool, error) {
+	if off >= end {
+		off = end - 1
+	}
+	for o := off + 1; o > s.baseOffset; o-- {
+		record, err := l.read(o - 1)
+		if err != nil {
+			return nil, false, err
+		}
+		if !bytes.Equal(record.Key, key) {
+			continue
+		}
+		if committed && (record.Control != api.ControlType_CONTROL_NONE || l.txns.isAborted(record)) {
+			continue
+		}
+		return record, true, nil
+	}
+	return nil, false, nil
+}
+
+// アクティブセグメントの索引は Log の排他ロックでしか書き換えないのでそのまま引く
+func (l *Log) lookupKey(s *segment, key []byte) (uint64, bool, error) {
+	if s == l.activeSegment {
+		off, ok := s.keys.lookup(key)
+		return off, ok, nil
+	}
+	return l.cache.lookupKey(s, key)
+}
@@ -0,0 +1,163 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLatestByKey(t *testing.T) {
+	dir, err := os.MkdirTemp("", "keyindex_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Segment.MaxStoreBytes = 64
+	conf.KeyIndex.Enabled = true
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+
+	latest := map[string]uint64{}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i%3)
+		off, err := log.Append(&api.Record{Key: []byte(key), Value: []byte(fmt.Sprintf("value-%d", i))})
+		require.NoError(t, err)
+		latest[key] = off
+	}
+	_, err = log.Append(&api.Record{Value: []byte("no key")})
+	require.NoError(t, err)
+	require.Greater(t, len(log.segments), 3)
+
+	check := func(log *Log) {
+		for key, off := range latest {
+			record, err := log.ReadLatestByKey([]byte(key), api.IsolationLevel_READ_UNCOMMITTED)
+			require.NoError(t, err)
+			require.Equal(t, off, record.Offset)
+		}
+		_, err = log.ReadLatestByKey([]byte("unknown"), api.IsolationLevel_READ_UNCOMMITTED)
+		require.ErrorAs(t, err, &api.ErrKeyNotFound{})
+	}
+	check(log)
+
+	// 開き直した場合は索引を作り直す
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, conf)
+	require.NoError(t, err)
+	check(log)
+
+	// 閉じたセグメントはブルームフィルタだけ残し、キーごとのオフセットは作り直す
+	require.NoError(t, log.Close())
+	evicting := conf
+	evicting.SegmentCache.MaxOpenSegments = 1
+	log, err = NewLog(dir, evicting)
+	require.NoError(t, err)
+	defer log.Close()
+	check(log)
+	// 共有ロックで並行して引いても、開閉はキャッシュのロックで排他される
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key, off := range latest {
+				record, err := log.ReadLatestByKey([]byte(key), api.IsolationLevel_READ_UNCOMMITTED)
+				require.NoError(t, err)
+				require.Equal(t, off, record.Offset)
+			}
+		}()
+	}
+	wg.Wait()
+	closed := 0
+	for _, s := range log.segments {
+		if s.isOpen() || s.keys == nil {
+			continue
+		}
+		require.Nil(t, s.keys.offsets)
+		require.NotNil(t, s.keys.bloom)
+		closed++
+	}
+	require.NotZero(t, closed)
+
+	_, err = log.Append(&api.Record{Key: []byte("key-0"), ExpiresAt: time.Now().Add(-time.Minute).UnixMilli()})
+	require.NoError(t, err)
+	_, err = log.ReadLatestByKey([]byte("key-0"), api.IsolationLevel_READ_UNCOMMITTED)
+	require.ErrorAs(t, err, &api.ErrRecordExpired{})
+
+	disabled := conf
+	disabled.KeyIndex.Enabled = false
+	require.Error(t, log.UpdateConfig(disabled))
+}
+
+func TestReadLatestByKeyCommitted(t *testing.T) {
+	dir, err := os.MkdirTemp("", "keyindex_committed_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Segment.MaxStoreBytes = 64
+	conf.KeyIndex.Enabled = true
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+
+	requireValue := func(isolation api.IsolationLevel, want string) {
+		t.Helper()
+		record, err := log.ReadLatestByKey([]byte("k"), isolation)
+		require.NoError(t, err)
+		require.Equal(t, want, string(record.Value))
+	}
+	_, err = log.Append(&api.Record{Key: []byte("k"), Value: []byte("v1")})
+	require.NoError(t, err)
+	_, err = log.Append(&api.Record{Key: []byte("other"), Value: []byte("x")})
+	require.NoError(t, err)
+
+	// 未確定のレコードは read_committed では読めず、前のコミット済みのレコードを返す
+	require.NoError(t, log.BeginTransaction("txn"))
+	_, err = log.Append(&api.Record{Key: []byte("k"), Value: []byte("v2"), TransactionId: "txn"})
+	require.NoError(t, err)
+	requireValue(api.IsolationLevel_READ_UNCOMMITTED, "v2")
+	requireValue(api.IsolationLevel_READ_COMMITTED, "v1")
+
+	_, err = log.AbortTransaction("txn")
+	require.NoError(t, err)
+	requireValue(api.IsolationLevel_READ_COMMITTED, "v1")
+
+	require.NoError(t, log.BeginTransaction("txn2"))
+	_, err = log.Append(&api.Record{Key: []byte("k"), Value: []byte("v3"), TransactionId: "txn2"})
+	require.NoError(t, err)
+	_, err = log.CommitTransaction("txn2")
+	require.NoError(t, err)
+	requireValue(api.IsolationLevel_READ_COMMITTED, "v3")
+
+	_, err = log.ReadLatestByKey([]byte("unknown"), api.IsolationLevel_READ_COMMITTED)
+	require.ErrorAs(t, err, &api.ErrKeyNotFound{})
+
+	disabledDir, err := os.MkdirTemp("", "keyindex_disabled_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(disabledDir)
+	disabled, err := NewLog(disabledDir, Config{})
+	require.NoError(t, err)
+	defer disabled.Close()
+	_, err = disabled.ReadLatestByKey([]byte("k"), api.IsolationLevel_READ_UNCOMMITTED)
+	require.ErrorAs(t, err, &api.ErrKeyIndexDisabled{})
+}
+
+func TestBloomFilter(t *testing.T) {
+	b := newBloomFilter(100)
+	for i := 0; i < 100; i++ {
+		b.add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	falsePositives := 0
+	for i := 0; i < 100; i++ {
+		require.True(t, b.mayContain([]byte(fmt.Sprintf("key-%d", i))))
+		if b.mayContain([]byte(fmt.Sprintf("other-%d", i))) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 10)
+}
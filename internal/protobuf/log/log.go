@@ -1,25 +1,35 @@
 package log
 
 import (
+	"fmt"
 	"io"
-	"os"
-	"path"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	api "github.com/Symthy/golang-distributed-service-study/api/v1"
-	"github.com/Symthy/golang-distributed-service-study/internal/collections"
 )
 
 type CommitLog interface {
 	Append(record *api.Record) (uint64, error)
 	Read(offset uint64) (*api.Record, error)
+	ReadCommitted(offset uint64) (*api.Record, error)
+	ReadLatestByKey(key []byte, isolation api.IsolationLevel) (*api.Record, error)
+	AppendBatch(records []*api.Record) (uint64, error)
+	ReadRange(from, to uint64, limit int, isolation api.IsolationLevel) ([]*api.Record, uint64, error)
+	OffsetForTime(timestamp int64) (uint64, error)
+	Offsets() (low, high uint64)
+	LastStableOffset() uint64
+	BeginTransaction(id string) error
+	CommitTransaction(id string) (uint64, error)
+	AbortTransaction(id string) (uint64, error)
 	Flush() error
 	Close() error
 	Remove() error
 	Truncate(lowest uint64) error
 	Reader() io.Reader
+	Changed() <-chan struct{}
+	CheckWritable() error
+	CheckStorage() error
 }
 
 type Log struct {
@@ -28,6 +38,18 @@ type Log struct {
 	conf          Config
 	activeSegment *segment
 	segments      []*segment
+	producers     *producerStates
+	txns          *transactions
+	blobs         *blobRefs
+	stopReaper    chan struct{}
+	storage       storageGuard
+	prepared      *preparedSegment
+	cache         *segmentCache
+	// 最後に追記したレコードの追記時刻
+	lastTimestamp int64
+	// 追記のたびに閉じて作り直す。追記を待つ側に知らせる
+	changed chan struct{}
+	closed  bool
 }
 
 func NewLog(dir string, conf Config) (*Log, error) {
@@ -37,73 +59,187 @@ func NewLog(dir string, conf Config) (*Log, error) {
 	if conf.Segment.MaxIndexBytes == 0 {
 		conf.Segment.MaxIndexBytes = 1024
 	}
+	if conf.Transaction.Timeout == 0 {
+		conf.Transaction.Timeout = defaultTransactionTimeout
+	}
+	if conf.FS == nil {
+		conf.FS = OSFS{}
+	}
 	l := &Log{
-		dir:  dir,
-		conf: conf,
+		dir:     dir,
+		conf:    conf,
+		cache:   newSegmentCache(conf.SegmentCache.MaxOpenSegments),
+		changed: make(chan struct{}),
 	}
 	return l, l.setup()
 }
 
-func (l *Log) setup() error {
-	if err := l.restoreSegment(); err != nil {
+// 設定を実行中に変更する。セグメントの上限は次のロールで作成するセグメントから適用し、
+// 既存のセグメントは作成時の設定のまま扱う
+func (l *Log) UpdateConfig(conf Config) error {
+	if conf.Transaction.Timeout == 0 {
+		conf.Transaction.Timeout = l.conf.Transaction.Timeout
+	}
+	if conf.FS == nil {
+		conf.FS = l.conf.FS
+	}
+	if err := conf.validate(); err != nil {
 		return err
 	}
 
-	if l.segments != nil {
-		return nil
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if conf.Segment.InitialOffset != l.conf.Segment.InitialOffset {
+		return fmt.Errorf("invalid config: InitialOffset cannot be changed")
 	}
-	if err := l.newSegment(l.conf.Segment.InitialOffset); err != nil {
+	if conf.FS != l.conf.FS {
+		return fmt.Errorf("invalid config: FS cannot be changed")
+	}
+	if conf.KeyIndex != l.conf.KeyIndex {
+		return fmt.Errorf("invalid config: KeyIndex cannot be changed")
+	}
+	if err := l.cache.setMax(conf.SegmentCache.MaxOpenSegments); err != nil {
 		return err
 	}
+	if conf.Segment != l.conf.Segment && l.prepared != nil {
+		// 古い設定で事前作成したファイルは使わない
+		l.prepared.discard()
+		l.prepared = nil
+	}
+	l.conf = conf
 	return nil
 }
 
-func (l *Log) newSegment(offset uint64) error {
-	seg, err := newSegment(l.dir, offset, l.conf)
-	if err != nil {
+func (l *Log) Config() Config {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.conf
+}
+
+func (l *Log) setup() error {
+	if err := l.restoreSegment(); err != nil {
 		return err
 	}
-	l.segments = append(l.segments, seg)
-	l.activeSegment = seg
+
+	if l.segments == nil {
+		if err := l.newSegment(l.conf.Segment.InitialOffset); err != nil {
+			return err
+		}
+	}
+	if err := l.saveManifest(); err != nil {
+		return err
+	}
+	if err := l.restoreProducers(); err != nil {
+		return err
+	}
+	if err := l.restoreTransactions(); err != nil {
+		return err
+	}
+	if err := l.restoreBlobs(); err != nil {
+		return err
+	}
+	if err := l.restoreLastTimestamp(); err != nil {
+		return err
+	}
+	l.closed = false
+	l.stopReaper = make(chan struct{})
+	l.startTransactionReaper()
+	l.startExpiryReaper()
 	return nil
 }
 
-func (l *Log) restoreSegment() error {
-	files, err := os.ReadDir(l.dir)
+func (l *Log) newSegment(offset uint64) error {
+	seg, err := newSegment(l.dir, offset, l.conf)
 	if err != nil {
 		return err
 	}
+	return l.addSegment(seg)
+}
 
-	var baseOffsets []uint64
-	for _, file := range files {
-		extention := path.Ext(file.Name()) // ファイル拡張子取得
-		if extention != storeFileExtention {
-			continue
+// 新しいアクティブセグメントを加える。それまでのアクティブセグメントは開いたままキャッシュに移す
+func (l *Log) addSegment(seg *segment) error {
+	if prev := l.activeSegment; prev != nil {
+		// MANIFEST は起動時に封印済みのセグメントの次のオフセットを信用するので、保存する前に同期する
+		if err := prev.seal(); err != nil {
+			return err
 		}
-		offStr := strings.TrimSuffix(
-			file.Name(),
-			extention,
-		)
-		off, _ := strconv.ParseUint(offStr, 10, 0)
-		baseOffsets = append(baseOffsets, off)
-	}
-	collections.SortAsc(baseOffsets)
-
-	for i := 0; i < len(baseOffsets); i++ {
-		if err = l.newSegment(baseOffsets[i]); err != nil {
+		if err := l.cache.add(prev); err != nil {
 			return err
 		}
 	}
-	return nil
+	l.segments = append(l.segments, seg)
+	l.activeSegment = seg
+	return l.saveManifest()
 }
 
 func (l *Log) Append(record *api.Record) (uint64, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	if record.Control != api.ControlType_CONTROL_NONE {
+		return 0, api.ErrInvalidRecord{
+			Field:  "record.control",
+			Reason: fmt.Sprintf("control record cannot be appended: %s", record.Control),
+		}
+	}
+	if record.BlobRef != "" {
+		return 0, api.ErrInvalidRecord{
+			Field:  "record.blob_ref",
+			Reason: fmt.Sprintf("blob reference cannot be appended: %s", record.BlobRef),
+		}
+	}
+	now := time.Now()
+	setExpiry(record, now)
+	setTimestamp(record, now, l.lastTimestamp)
+	if err := l.checkRecordSize(record); err != nil {
+		return 0, err
+	}
+	if record.TransactionId != "" {
+		if _, ok := l.txns.Open[record.TransactionId]; !ok {
+			return 0, api.ErrTransactionNotFound{TransactionId: record.TransactionId}
+		}
+	}
+	if record.ProducerId != "" {
+		off, duplicated, err := l.producers.check(record.ProducerId, record.Sequence)
+		if err != nil {
+			return 0, err
+		}
+		if duplicated {
+			return off, nil // リトライによる重複は追記せず元のオフセットを返す
+		}
+	}
+	stored, err := l.offload(record)
+	if err != nil {
+		return 0, err
+	}
+	off, err := l.append(stored)
+	if err != nil {
+		return 0, err
+	}
+	record.Offset = off
+	return off, nil
+}
+
+func (l *Log) append(record *api.Record) (uint64, error) {
+	off, err := l.write(record)
+	if err != nil {
+		return 0, err
+	}
+	l.applyAppended(record, time.Now())
+	l.notifyChanged()
+	return off, nil
+}
+
+// セグメントへの書き込みのみ行う。プロデューサーとトランザクションの状態は呼び出し側で更新する
+func (l *Log) write(record *api.Record) (uint64, error) {
+	size, err := l.checkStorage(record)
+	if err != nil {
+		return 0, err
+	}
 	if l.activeSegment.IsMaxed() {
 		highestOffset := l.highestOffset()
-		err := l.newSegment(highestOffset + 1)
+		err := l.rollSegment(highestOffset + 1)
 		if err != nil {
 			return 0, err
 		}
@@ -113,9 +249,30 @@ func (l *Log) Append(record *api.Record) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
+	l.storage.writtenBytes += size
+	l.prepareNextSegment()
 	return off, nil
 }
 
+func (l *Log) applyAppended(record *api.Record, now time.Time) {
+	l.producers.update(record)
+	l.txns.apply(record, now)
+	l.addBlobRef(record)
+	l.lastTimestamp = record.Timestamp
+}
+
+func (l *Log) notifyChanged() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// 次に追記された時に閉じるチャネルを返す。読み出しの前に取得しておけば追記を取りこぼさない
+func (l *Log) Changed() <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.changed
+}
+
 func (l *Log) highestOffset() uint64 {
 	off := l.segments[len(l.segments)-1].nextOffset
 	if off == 0 {
@@ -127,12 +284,30 @@ func (l *Log) highestOffset() uint64 {
 func (l *Log) Read(offset uint64) (*api.Record, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	record, err := l.read(offset)
+	if err != nil {
+		return nil, err
+	}
+	if isExpired(record, time.Now()) {
+		return nil, api.ErrRecordExpired{Offset: offset}
+	}
+	return record, nil
+}
 
+func (l *Log) read(offset uint64) (*api.Record, error) {
 	s := l.getSegmentIfContains(offset)
 	if s == nil || s.nextOffset <= offset {
 		return nil, api.ErrOffsetOutOfRange{Offset: offset}
 	}
-	return s.Read(offset)
+	if err := l.acquire(s); err != nil {
+		return nil, err
+	}
+	record, err := s.Read(offset)
+	l.release(s)
+	if err != nil {
+		return nil, err
+	}
+	return record, l.resolveBlob(record)
 }
 
 func (l *Log) getSegmentIfContains(offset uint64) *segment {
@@ -148,21 +323,65 @@ func (l *Log) Flush() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	for _, segment := range l.segments {
+		// 閉じているセグメントは閉じる時に書き出し済み
+		if !segment.isOpen() {
+			continue
+		}
 		if err := segment.Flush(); err != nil {
 			return err
 		}
 	}
-	return nil
+	return l.saveSnapshots()
+}
+
+func (l *Log) saveSnapshots() error {
+	if err := l.producers.save(l.conf.fs(), l.dir, l.activeSegment.nextOffset); err != nil {
+		return err
+	}
+	if err := l.blobs.save(l.conf.fs(), l.dir, l.activeSegment.nextOffset); err != nil {
+		return err
+	}
+	lowest := l.activeSegment.nextOffset
+	if len(l.segments) > 0 {
+		lowest = l.segments[0].baseOffset
+	}
+	return l.txns.save(l.conf.fs(), l.dir, lowest, l.activeSegment.nextOffset)
 }
 
 func (l *Log) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.stopReaper != nil {
+		close(l.stopReaper)
+		l.stopReaper = nil
+	}
+	if l.prepared != nil {
+		l.prepared.discard()
+		l.prepared = nil
+	}
+	if err := l.saveSnapshots(); err != nil {
+		return err
+	}
 	for _, segment := range l.segments {
 		if err := segment.Close(); err != nil {
 			return err
 		}
 	}
+	l.cache = newSegmentCache(l.conf.SegmentCache.MaxOpenSegments)
+	l.closed = true
+	return nil
+}
+
+// 追記できる状態かを返す。ヘルスチェック用
+func (l *Log) CheckWritable() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return fmt.Errorf("log is closed")
+	}
+	if !l.activeSegment.isOpen() {
+		return fmt.Errorf("active segment is not open")
+	}
 	return nil
 }
 
@@ -170,7 +389,7 @@ func (l *Log) Remove() error {
 	if err := l.Close(); err != nil {
 		return err
 	}
-	return os.RemoveAll(l.dir)
+	return l.conf.fs().RemoveAll(l.dir)
 }
 
 func (l *Log) Reset() error {
@@ -185,17 +404,90 @@ func (l *Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	var newSegments []*segment
+	var newSegments, removed []*segment
 	for _, s := range l.segments {
 		if s.nextOffset > lowest+1 {
 			newSegments = append(newSegments, s)
-			continue
+		} else {
+			removed = append(removed, s)
+		}
+	}
+	// 途中で終了しても残ったファイルは起動時に消せるよう、先に MANIFEST から外す
+	l.segments = newSegments
+	if err := l.saveManifest(); err != nil {
+		return err
+	}
+	for _, s := range removed {
+		if err := s.Remove(); err != nil {
+			return err
 		}
+		l.cache.remove(s)
+	}
+	if err := l.removeBlobRefs(removed); err != nil {
+		return err
+	}
+	l.storage.checkedAt = time.Time{} // 空いた容量をすぐに反映する
+	return nil
+}
+
+// 指定したオフセットより後のレコードを削除する
+func (l *Log) TruncateAfter(offset uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if offset < l.segments[0].baseOffset {
+		return api.ErrOffsetOutOfRange{Offset: offset}
+	}
+	next := offset + 1
+	if next >= l.activeSegment.nextOffset {
+		return nil
+	}
+	if err := l.truncateAfter(next); err != nil {
+		return err
+	}
+	l.producers.truncate(next)
+	l.txns.truncate(next, time.Now())
+	return l.saveSnapshots()
+}
+
+// 指定したオフセット以降のレコードを削除する。最初のセグメントは空になっても残す
+func (l *Log) truncateAfter(next uint64) error {
+	i := len(l.segments) - 1
+	for i > 0 && l.segments[i].baseOffset >= next {
+		i--
+	}
+	removed := l.segments[i+1:]
+	last := l.segments[i]
+	if last != l.activeSegment {
+		if err := l.cache.take(last); err != nil {
+			return err
+		}
+	}
+	l.segments = l.segments[:i+1]
+	l.activeSegment = last
+	last.checksum, last.checksumKnown = 0, false
+	if err := l.saveManifest(); err != nil {
+		return err
+	}
+	for _, s := range removed {
 		if err := s.Remove(); err != nil {
 			return err
 		}
+		l.cache.remove(s)
 	}
-	l.segments = newSegments
+	if err := last.truncate(next); err != nil {
+		return err
+	}
+	if err := l.removeBlobRefs(removed); err != nil {
+		return err
+	}
+	if err := l.rebuildBlobRefs(last); err != nil {
+		return err
+	}
+	if err := l.restoreLastTimestamp(); err != nil {
+		return err
+	}
+	l.storage.checkedAt = time.Time{}
 	return nil
 }
 
@@ -204,7 +496,7 @@ func (l *Log) Reader() io.Reader {
 	defer l.mu.RUnlock()
 	readers := make([]io.Reader, len(l.segments))
 	for i, segment := range l.segments {
-		readers[i] = &originReader{segment.store, 0}
+		readers[i] = &originReader{log: l, segment: segment}
 	}
 	return io.MultiReader(readers...)
 }
@@ -222,12 +514,20 @@ func (l *Log) HighestOffset() uint64 {
 }
 
 type originReader struct {
-	*store
-	offset int64
+	log     *Log
+	segment *segment
+	offset  int64
 }
 
+// 封印済みのセグメントは読み出しの間だけ開いておく
 func (o *originReader) Read(p []byte) (int, error) {
-	n, err := o.ReadAt(p, o.offset)
-	o.offset = int64(n)
+	o.log.mu.RLock()
+	defer o.log.mu.RUnlock()
+	if err := o.log.acquire(o.segment); err != nil {
+		return 0, err
+	}
+	defer o.log.release(o.segment)
+	n, err := o.segment.store.ReadAt(p, o.offset)
+	o.offset += int64(n)
 	return n, err
 }
@@ -19,6 +19,7 @@ func TestLog(t *testing.T) {
 		"new log with existing segments": testNewExisting,
 		"reader":                         testReader,
 		"truncate":                       testTruncate,
+		"changed":                        testChanged,
 	} {
 		t.Run(senario, func(t *testing.T) {
 			dir, err := os.MkdirTemp("", "log_test")
@@ -54,6 +55,24 @@ func testAppendRead(t *testing.T, log *Log) {
 	})
 }
 
+func testChanged(t *testing.T, log *Log) {
+	changed := log.Changed()
+	select {
+	case <-changed:
+		t.Fatal("closed before append")
+	default:
+	}
+
+	_, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("not closed after append")
+	}
+	require.NotEqual(t, changed, log.Changed())
+}
+
 func testOutOfRangeErr(t *testing.T, log *Log) {
 	readdata, err := log.Read(1)
 	require.Nil(t, readdata)
@@ -114,3 +133,38 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+func TestTruncateAfter(t *testing.T) {
+	fs := NewMemFS()
+	require.NoError(t, fs.MkdirAll("/log", 0700))
+	conf := newMemConfig(fs)
+	log, err := NewLog("/log", conf)
+	require.NoError(t, err)
+	appendValues(t, log, 0, 8)
+	_, err = log.Append(&api.Record{Value: []byte("produced"), ProducerId: "producer", Sequence: 0})
+	require.NoError(t, err)
+	require.Greater(t, len(log.segments), 2)
+
+	require.NoError(t, log.TruncateAfter(3))
+	requireValues(t, log, 4)
+	_, err = log.Read(4)
+	require.ErrorAs(t, err, &api.ErrOffsetOutOfRange{})
+
+	// 切り捨てたレコードのシーケンスは重複と見なさない
+	off, err := log.Append(&api.Record{Value: []byte("produced"), ProducerId: "producer", Sequence: 0})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+	require.NoError(t, log.TruncateAfter(3))
+	appendValues(t, log, 4, 6)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog("/log", conf)
+	require.NoError(t, err)
+	defer log.Close()
+	requireValues(t, log, 6)
+	require.NoError(t, log.Verify())
+
+	err = log.TruncateAfter(100)
+	require.NoError(t, err)
+	requireValues(t, log, 6)
+}
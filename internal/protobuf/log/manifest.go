@@ -0,0 +1,226 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Symthy/golang-distributed-service-study/internal/collections"
+)
+
+const (
+	manifestFile = "MANIFEST"
+	// ストアは長さ付きの proto、インデックスは entryWidth 幅のエントリ
+	segmentFormat = 1
+)
+
+// ログを構成するセグメントの一覧。起動時はディレクトリの内容ではなくこれを正とする
+type manifest struct {
+	Segments []manifestSegment `json:"segments"`
+}
+
+type manifestSegment struct {
+	BaseOffset uint64 `json:"base_offset"`
+	// アクティブセグメントは書き込みのたびには更新しないので、起動時はインデックスから求める
+	NextOffset uint64 `json:"next_offset"`
+	Format     int    `json:"format"`
+	// 封印済みのセグメントのストアの CRC-32。アクティブセグメントと、まだ求めていないセグメントは nil
+	Checksum *uint32 `json:"checksum,omitempty"`
+	// 封印済みのセグメントのストアのサイズ。0 の場合は確かめない
+	StoreBytes uint64 `json:"store_bytes,omitempty"`
+}
+
+// セグメントの追加・削除のたびに一時ファイルに書いてからリネームする
+func (l *Log) saveManifest() error {
+	m := manifest{Segments: make([]manifestSegment, 0, len(l.segments))}
+	for _, s := range l.segments {
+		ms := manifestSegment{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			Format:     segmentFormat,
+		}
+		if s != l.activeSegment {
+			if s.checksumKnown {
+				sum := s.checksum
+				ms.Checksum = &sum
+			}
+			ms.StoreBytes = s.storeBytes
+		}
+		m.Segments = append(m.Segments, ms)
+	}
+	return saveSnapshot(l.conf.fs(), l.dir, manifestFile, m)
+}
+
+func (l *Log) restoreSegment() error {
+	if err := removePreparedFiles(l.conf.fs(), l.dir); err != nil {
+		return err
+	}
+	m := manifest{}
+	found, err := loadSnapshot(l.conf.fs(), l.dir, manifestFile, &m)
+	if err != nil {
+		return err
+	}
+	if !found || l.conf.Manifest.Rebuild {
+		return l.scanSegments()
+	}
+	if len(m.Segments) == 0 {
+		return nil
+	}
+
+	bases := map[uint64]bool{}
+	for _, ms := range m.Segments {
+		if ms.Format != segmentFormat {
+			return fmt.Errorf("segment %d has unsupported format %d", ms.BaseOffset, ms.Format)
+		}
+		bases[ms.BaseOffset] = true
+	}
+	// ロールや Truncate の途中で終了した場合に残った、MANIFEST にないセグメントのファイルを消す
+	if err = removeOrphanSegments(l.conf.fs(), l.dir, bases); err != nil {
+		return err
+	}
+
+	last := len(m.Segments) - 1
+	for i, ms := range m.Segments[:last] {
+		short, err := isShortStore(l.conf.fs(), l.dir, ms)
+		if err != nil {
+			return err
+		}
+		if short {
+			// 書き出す前に終了し、ファイルが MANIFEST と食い違っている。
+			// このセグメントで読めた所までを残してアクティブセグメントにし、後続は捨てる
+			if err = removeSegmentFiles(l.conf.fs(), l.dir, m.Segments[i+1:]); err != nil {
+				return err
+			}
+			return l.newSegment(ms.BaseOffset)
+		}
+		s := newSealedSegment(l.dir, ms.BaseOffset, ms.NextOffset, l.conf)
+		if ms.Checksum != nil {
+			s.checksum, s.checksumKnown = *ms.Checksum, true
+		}
+		s.storeBytes = ms.StoreBytes
+		l.segments = append(l.segments, s)
+	}
+	return l.newSegment(m.Segments[last].BaseOffset)
+}
+
+// ストアのファイルが封印時より短いか。ファイルがない場合は読み出し時にエラーにするのでここでは扱わない
+func isShortStore(fs FS, dir string, ms manifestSegment) (bool, error) {
+	fi, err := fs.Stat(segmentPath(dir, ms.BaseOffset, storeFileExtention))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return uint64(fi.Size()) < ms.StoreBytes, nil
+}
+
+func removeSegmentFiles(fs FS, dir string, segments []manifestSegment) error {
+	for _, ms := range segments {
+		for _, extention := range []string{storeFileExtention, indexFileExtention, configFileExtention} {
+			if err := fs.Remove(segmentPath(dir, ms.BaseOffset, extention)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MANIFEST がない、または作り直す場合はディレクトリを走査してセグメントを復元する
+func (l *Log) scanSegments() error {
+	baseOffsets, err := listSegments(l.conf.fs(), l.dir)
+	if err != nil {
+		return err
+	}
+	if len(baseOffsets) == 0 {
+		return nil
+	}
+
+	// 封印済みのセグメントは読み出すまで開かない。チェックサムも開いた時か Verify で求める
+	last := len(baseOffsets) - 1
+	for i := 0; i < last; i++ {
+		l.segments = append(l.segments, newSealedSegment(l.dir, baseOffsets[i], baseOffsets[i+1], l.conf))
+	}
+	return l.newSegment(baseOffsets[last])
+}
+
+// ファイル名がベースオフセットのストアファイルを探す
+func listSegments(fs FS, dir string) ([]uint64, error) {
+	files, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var baseOffsets []uint64
+	for _, file := range files {
+		extention := path.Ext(file.Name()) // ファイル拡張子取得
+		if extention != storeFileExtention {
+			continue
+		}
+		off, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), extention), 10, 64)
+		if err != nil {
+			continue
+		}
+		baseOffsets = append(baseOffsets, off)
+	}
+	collections.SortAsc(baseOffsets)
+	return baseOffsets, nil
+}
+
+func removeOrphanSegments(fs FS, dir string, bases map[uint64]bool) error {
+	files, err := fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		extention := path.Ext(file.Name())
+		if extention != storeFileExtention && extention != indexFileExtention && extention != configFileExtention {
+			continue
+		}
+		off, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), extention), 10, 64)
+		if err != nil || bases[off] {
+			continue
+		}
+		if err = fs.Remove(filepath.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fileChecksum(fs FS, name string) (uint32, error) {
+	b, err := fs.ReadFile(name)
+	if err != nil {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(b), nil
+}
+
+// 封印済みのセグメントのストアが MANIFEST のチェックサムと一致するか確認する。
+// チェックサムをまだ求めていないセグメントは、ここで求めた値を記録する
+func (l *Log) Verify() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var corrupted []string
+	for _, s := range l.segments {
+		if s == l.activeSegment {
+			continue
+		}
+		sum, err := fileChecksum(l.conf.fs(), segmentPath(l.dir, s.baseOffset, storeFileExtention))
+		if err != nil {
+			return err
+		}
+		if !l.cache.verify(s, sum) {
+			corrupted = append(corrupted, strconv.FormatUint(s.baseOffset, 10))
+		}
+	}
+	if len(corrupted) > 0 {
+		return fmt.Errorf("checksum mismatch in segments: %s", strings.Join(corrupted, ", "))
+	}
+	return nil
+}
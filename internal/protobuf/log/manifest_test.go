@@ -0,0 +1,192 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	for senario, fn := range map[string]func(*testing.T, string, Config){
+		"stray files are ignored":           testManifestStrayFiles,
+		"interrupted truncate is cleaned":   testManifestInterruptedTruncate,
+		"missing manifest is rebuilt":       testManifestRebuild,
+		"corrupted segment fails verify":    testManifestVerify,
+		"missing sealed segment read fails": testManifestMissingSegment,
+		"reactivated segment checksum":      testManifestReactivatedChecksum,
+		"short sealed segment keeps prefix": testManifestShortSegment,
+	} {
+		t.Run(senario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "manifest_test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			conf := Config{}
+			conf.Segment.MaxStoreBytes = 10
+			conf.Segment.MaxIndexBytes = 1024
+			log, err := NewLog(dir, conf)
+			require.NoError(t, err)
+			for i := 0; i < 5; i++ {
+				_, err = log.Append(&api.Record{Value: []byte(fmt.Sprintf("%d", i))})
+				require.NoError(t, err)
+			}
+			require.Equal(t, 5, len(log.segments))
+			require.NoError(t, log.Verify())
+			require.NoError(t, log.Close())
+
+			fn(t, dir, conf)
+		})
+	}
+}
+
+func requireRecords(t *testing.T, log *Log, from, to uint64) {
+	t.Helper()
+	require.Equal(t, from, log.LowestOffset())
+	require.Equal(t, to, log.HighestOffset())
+	for off := from; off <= to; off++ {
+		record, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("%d", off)), record.Value)
+	}
+}
+
+func testManifestStrayFiles(t *testing.T, dir string, conf Config) {
+	stray := []string{"garbage.store", "100.store", "100.index", "tmp.index"}
+	for _, name := range stray {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("stray"), 0600))
+	}
+
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+	requireRecords(t, log, 0, 4)
+
+	// MANIFEST にないセグメントのファイルは消し、それ以外は触らない
+	for _, name := range []string{"100.store", "100.index"} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		require.True(t, os.IsNotExist(err))
+	}
+	_, err = os.Stat(filepath.Join(dir, "garbage.store"))
+	require.NoError(t, err)
+}
+
+func testManifestInterruptedTruncate(t *testing.T, dir string, conf Config) {
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	store, err := os.ReadFile(segmentPath(dir, 0, storeFileExtention))
+	require.NoError(t, err)
+	require.NoError(t, log.Truncate(1))
+	require.NoError(t, log.Close())
+
+	// MANIFEST の更新後、ファイルの削除前に終了した状態
+	require.NoError(t, os.WriteFile(segmentPath(dir, 0, storeFileExtention), store, 0600))
+
+	log, err = NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+	requireRecords(t, log, 2, 4)
+	_, err = os.Stat(segmentPath(dir, 0, storeFileExtention))
+	require.True(t, os.IsNotExist(err))
+}
+
+func testManifestRebuild(t *testing.T, dir string, conf Config) {
+	require.NoError(t, os.Remove(filepath.Join(dir, manifestFile)))
+
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	// 起動時には封印済みのストアを読まず、最初に開いた時にチェックサムを求める
+	for _, s := range log.segments[:len(log.segments)-1] {
+		require.False(t, s.checksumKnown)
+	}
+	requireRecords(t, log, 0, 4)
+	require.True(t, log.segments[0].checksumKnown)
+	require.NoError(t, log.Verify())
+	require.NoError(t, log.Close())
+	_, err = os.Stat(filepath.Join(dir, manifestFile))
+	require.NoError(t, err)
+
+	// 設定で明示的に作り直すこともできる
+	conf.Manifest.Rebuild = true
+	log, err = NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+	requireRecords(t, log, 0, 4)
+}
+
+func testManifestVerify(t *testing.T, dir string, conf Config) {
+	path := segmentPath(dir, 1, storeFileExtention)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	b[len(b)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, b, 0600))
+
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+	require.ErrorContains(t, log.Verify(), "segments: 1")
+}
+
+func testManifestMissingSegment(t *testing.T, dir string, conf Config) {
+	require.NoError(t, os.Remove(segmentPath(dir, 1, storeFileExtention)))
+
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+	// 失われたセグメントを空のファイルで作り直さない
+	_, err = log.Read(1)
+	require.Error(t, err)
+	_, err = os.Stat(segmentPath(dir, 1, storeFileExtention))
+	require.True(t, os.IsNotExist(err))
+}
+
+func testManifestReactivatedChecksum(t *testing.T, dir string, conf Config) {
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	// 開き直した封印済みのセグメントをアクティブに戻すと CRC が分からなくなる
+	require.NoError(t, log.TruncateAfter(2))
+	_, err = log.Append(&api.Record{Value: []byte("3")})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(log.segments))
+
+	// 封印時にはストアを読まず、Verify で求めて記録する
+	require.False(t, log.segments[2].checksumKnown)
+	require.NoError(t, log.Verify())
+	require.True(t, log.segments[2].checksumKnown)
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+	requireRecords(t, log, 0, 3)
+	require.NoError(t, log.Verify())
+}
+
+func testManifestShortSegment(t *testing.T, dir string, conf Config) {
+	// 封印済みのセグメントを同期する前に終了し、MANIFEST だけが残った状態
+	require.NoError(t, os.Truncate(segmentPath(dir, 2, storeFileExtention), 0))
+
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	requireRecords(t, log, 0, 1)
+	require.Equal(t, 3, len(log.segments))
+	for _, base := range []uint64{3, 4} {
+		_, err = os.Stat(segmentPath(dir, base, storeFileExtention))
+		require.True(t, os.IsNotExist(err))
+	}
+	// 失われたオフセットから追記を続けられる
+	for i := 2; i < 5; i++ {
+		_, err = log.Append(&api.Record{Value: []byte(fmt.Sprintf("%d", i))})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+	requireRecords(t, log, 0, 4)
+	require.NoError(t, log.Verify())
+}
@@ -0,0 +1,158 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	preparedFileExtention = ".prepared"
+	preparedFilePrefix    = "next"
+	// アクティブセグメントの使用率がこれを超えたら次のセグメントを事前に作成する
+	prepareSegmentRatio = 0.75
+)
+
+// 次のセグメント用に事前に作成したファイル。
+// ファイル作成・インデックスの拡張・mmap をロック外で済ませておき、ロール時はリネームのみ行う
+type preparedSegment struct {
+	done      chan struct{}
+	config    Config
+	storeFile File
+	index     *index
+	err       error
+}
+
+func prepareSegment(dir string, config Config) *preparedSegment {
+	p := &preparedSegment{
+		done:   make(chan struct{}),
+		config: config,
+	}
+	go func() {
+		defer close(p.done)
+		p.storeFile, p.err = config.fs().OpenFile(
+			preparedPath(dir, storeFileExtention),
+			os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND,
+			0600,
+		)
+		if p.err != nil {
+			return
+		}
+		indexFile, err := config.fs().OpenFile(
+			preparedPath(dir, indexFileExtention),
+			os.O_RDWR|os.O_CREATE|os.O_TRUNC,
+			0600,
+		)
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.index, p.err = newIndex(indexFile, config)
+	}()
+	return p
+}
+
+func preparedPath(dir, extention string) string {
+	return filepath.Join(dir, preparedFilePrefix+extention+preparedFileExtention)
+}
+
+// 事前作成したファイルをベースオフセットの名前にしてセグメントとして使う
+func (p *preparedSegment) activate(dir string, baseOffset uint64) (*segment, error) {
+	<-p.done
+	if p.err != nil {
+		return nil, p.err
+	}
+	storePath := segmentPath(dir, baseOffset, storeFileExtention)
+	indexPath := segmentPath(dir, baseOffset, indexFileExtention)
+	fs := p.config.fs()
+	if err := fs.Rename(p.storeFile.Name(), storePath); err != nil {
+		return nil, err
+	}
+	if err := fs.Rename(p.index.Name(), indexPath); err != nil {
+		return nil, err
+	}
+	if err := saveSegmentConfig(dir, baseOffset, p.config); err != nil {
+		return nil, err
+	}
+
+	// os.File.Name() はリネーム前の名前を返すため開き直す。mmap はそのまま使える
+	storeFile, err := fs.OpenFile(storePath, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := fs.OpenFile(indexPath, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	p.storeFile.Close()
+	p.index.file.Close()
+	p.index.file = indexFile
+
+	s := &segment{
+		dir:         dir,
+		baseOffset:  baseOffset,
+		nextOffset:  baseOffset,
+		config:      p.config,
+		index:       p.index,
+		expiryKnown: true,
+	}
+	if s.store, err = newStore(storeFile); err != nil {
+		return nil, err
+	}
+	if p.config.KeyIndex.Enabled {
+		s.keys = newKeyIndex(p.config)
+	}
+	return s, nil
+}
+
+// 使われなかったファイルを削除する
+func (p *preparedSegment) discard() error {
+	<-p.done
+	if p.storeFile != nil {
+		p.storeFile.Close()
+		p.config.fs().Remove(p.storeFile.Name())
+	}
+	if p.index != nil {
+		p.index.Close()
+		p.config.fs().Remove(p.index.Name())
+	}
+	return p.err
+}
+
+// 前回の起動時に使われなかった事前作成ファイルを削除する
+func removePreparedFiles(fs FS, dir string) error {
+	for _, extention := range []string{storeFileExtention, indexFileExtention} {
+		if err := fs.Remove(preparedPath(dir, extention)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *segment) usage() float64 {
+	storeUsage := float64(s.store.size) / float64(s.config.Segment.MaxStoreBytes)
+	indexUsage := float64(s.index.size) / float64(s.config.Segment.MaxIndexBytes)
+	if storeUsage > indexUsage {
+		return storeUsage
+	}
+	return indexUsage
+}
+
+func (l *Log) prepareNextSegment() {
+	if l.prepared != nil || l.activeSegment.usage() < prepareSegmentRatio {
+		return
+	}
+	l.prepared = prepareSegment(l.dir, l.conf)
+}
+
+func (l *Log) rollSegment(offset uint64) error {
+	if p := l.prepared; p != nil {
+		l.prepared = nil
+		seg, err := p.activate(l.dir, offset)
+		if err == nil {
+			return l.addSegment(seg)
+		}
+		// 事前作成に失敗した場合はその場で作成する
+		p.discard()
+	}
+	return l.newSegment(offset)
+}
@@ -0,0 +1,66 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareSegment(t *testing.T) {
+	dir, err := os.MkdirTemp("", "prepare_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Segment.MaxIndexBytes = entryWidth * 4
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+
+	record := &api.Record{Value: []byte("hello world")}
+	for i := 0; i < 3; i++ {
+		_, err = log.Append(record)
+		require.NoError(t, err)
+	}
+	require.NotNil(t, log.prepared)
+	<-log.prepared.done
+	require.NoError(t, log.prepared.err)
+	require.FileExists(t, preparedPath(dir, storeFileExtention))
+	require.FileExists(t, preparedPath(dir, indexFileExtention))
+
+	t.Run("roll uses prepared files", func(t *testing.T) {
+		prepared := log.prepared
+		for i := 0; i < 2; i++ {
+			_, err = log.Append(record)
+			require.NoError(t, err)
+		}
+		require.Len(t, log.segments, 2)
+		require.Equal(t, prepared.index, log.activeSegment.index)
+		require.Equal(t, segmentPath(dir, 4, indexFileExtention), log.activeSegment.index.Name())
+		require.Equal(t, segmentPath(dir, 4, storeFileExtention), log.activeSegment.store.Name())
+		require.NoFileExists(t, preparedPath(dir, storeFileExtention))
+
+		got, err := log.Read(4)
+		require.NoError(t, err)
+		require.Equal(t, record.Value, got.Value)
+	})
+
+	t.Run("unused prepared files are removed on restart", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			_, err = log.Append(record)
+			require.NoError(t, err)
+		}
+		require.NotNil(t, log.prepared)
+		<-log.prepared.done
+		// Close せずにプロセスが終了した状態を再現する
+		require.NoError(t, log.Flush())
+		log.prepared = nil
+
+		restored, err := NewLog(dir, conf)
+		require.NoError(t, err)
+		require.NoFileExists(t, preparedPath(dir, storeFileExtention))
+		require.NoFileExists(t, preparedPath(dir, indexFileExtention))
+		require.Len(t, restored.segments, 2)
+	})
+}
@@ -0,0 +1,109 @@
+package log
+
+import (
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+)
+
+const (
+	producerSnapshotFile = "producers.snapshot"
+	// 重複判定のために保持する直近の sequence 数
+	producerWindowSize = 5
+)
+
+type sequenceOffset struct {
+	Sequence uint64 `json:"sequence"`
+	Offset   uint64 `json:"offset"`
+}
+
+type producerState struct {
+	Recent []sequenceOffset `json:"recent"` // 古い順
+}
+
+func (p *producerState) last() sequenceOffset {
+	return p.Recent[len(p.Recent)-1]
+}
+
+// プロデューサーごとの最終 sequence を管理する
+type producerStates struct {
+	// スナップショットに反映済みの次のオフセット
+	NextOffset uint64                    `json:"next_offset"`
+	Producers  map[string]*producerState `json:"producers"`
+}
+
+func newProducerStates() *producerStates {
+	return &producerStates{Producers: map[string]*producerState{}}
+}
+
+// 重複であれば元のオフセットと true を返す
+func (p *producerStates) check(producerId string, sequence uint64) (uint64, bool, error) {
+	state, ok := p.Producers[producerId]
+	if !ok {
+		return 0, false, nil
+	}
+	last := state.last()
+	if sequence == last.Sequence+1 {
+		return 0, false, nil
+	}
+	if sequence <= last.Sequence {
+		for _, so := range state.Recent {
+			if so.Sequence == sequence {
+				return so.Offset, true, nil
+			}
+		}
+	}
+	return 0, false, api.ErrOutOfOrderSequence{
+		ProducerId: producerId,
+		Expected:   last.Sequence + 1,
+		Actual:     sequence,
+	}
+}
+
+func (p *producerStates) update(record *api.Record) {
+	if record.ProducerId == "" {
+		return
+	}
+	state, ok := p.Producers[record.ProducerId]
+	if !ok {
+		state = &producerState{}
+		p.Producers[record.ProducerId] = state
+	}
+	state.Recent = append(state.Recent, sequenceOffset{Sequence: record.Sequence, Offset: record.Offset})
+	if len(state.Recent) > producerWindowSize {
+		state.Recent = state.Recent[len(state.Recent)-producerWindowSize:]
+	}
+}
+
+// 切り捨てたオフセット以降の記録を消す
+func (p *producerStates) truncate(next uint64) {
+	for id, state := range p.Producers {
+		var recent []sequenceOffset
+		for _, so := range state.Recent {
+			if so.Offset < next {
+				recent = append(recent, so)
+			}
+		}
+		if len(recent) == 0 {
+			delete(p.Producers, id)
+			continue
+		}
+		state.Recent = recent
+	}
+}
+
+func (p *producerStates) save(fs FS, dir string, nextOffset uint64) error {
+	p.NextOffset = nextOffset
+	return saveSnapshot(fs, dir, producerSnapshotFile, p)
+}
+
+// スナップショット以降のレコードを読み直してプロデューサーの状態を復元する
+func (l *Log) restoreProducers() error {
+	p := newProducerStates()
+	if _, err := loadSnapshot(l.conf.fs(), l.dir, producerSnapshotFile, p); err != nil {
+		return err
+	}
+	if err := l.replay(p.NextOffset, p.update); err != nil {
+		return err
+	}
+	l.producers = p
+	return nil
+}
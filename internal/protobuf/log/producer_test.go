@@ -0,0 +1,78 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotentProducer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "producer_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Segment.MaxStoreBytes = 1024
+	l, err := NewLog(dir, conf)
+	require.NoError(t, err)
+
+	produce := func(l *Log, producerId string, sequence uint64) (uint64, error) {
+		return l.Append(&api.Record{
+			Value:      []byte("hello world"),
+			ProducerId: producerId,
+			Sequence:   sequence,
+		})
+	}
+
+	for i := uint64(0); i < 3; i++ {
+		off, err := produce(l, "p1", i)
+		require.NoError(t, err)
+		require.Equal(t, i, off)
+	}
+
+	t.Run("duplicate returns original offset", func(t *testing.T) {
+		off, err := produce(l, "p1", 1)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), off)
+		require.Equal(t, uint64(2), l.HighestOffset())
+	})
+
+	t.Run("out of order sequence fails", func(t *testing.T) {
+		_, err := produce(l, "p1", 5)
+		require.ErrorAs(t, err, &api.ErrOutOfOrderSequence{})
+		apiErr := err.(api.ErrOutOfOrderSequence)
+		require.Equal(t, uint64(3), apiErr.Expected)
+	})
+
+	t.Run("other producer is independent", func(t *testing.T) {
+		off, err := produce(l, "p2", 10)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), off)
+	})
+
+	t.Run("recover state on restart", func(t *testing.T) {
+		// スナップショット以降の追記もレコードから復元されること
+		require.NoError(t, l.Flush())
+		off, err := produce(l, "p1", 3)
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), off)
+		require.NoError(t, l.activeSegment.Flush())
+		require.NoError(t, l.segments[0].Close())
+
+		restored, err := NewLog(dir, conf)
+		require.NoError(t, err)
+		defer restored.Close()
+
+		off, err = produce(restored, "p1", 3)
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), off)
+		off, err = produce(restored, "p2", 10)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), off)
+		off, err = produce(restored, "p1", 4)
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), off)
+	})
+}
@@ -1,6 +1,8 @@
 package log
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,52 +12,175 @@ import (
 )
 
 const (
-	storeFileExtention = ".store"
-	indexFileExtention = ".index"
+	storeFileExtention  = ".store"
+	indexFileExtention  = ".index"
+	configFileExtention = ".config"
 )
 
 type segment struct {
+	dir string
+	// 封印済みのセグメントは読み出すまで開かない。閉じている間は nil
 	store                  *store
 	index                  *index
 	baseOffset, nextOffset uint64
 	config                 Config
+	// 読み出し中の数。参照中のセグメントはキャッシュから追い出さない
+	refs int
+	// 閉じている間のファイルサイズ。封印済みのセグメントは変わらないので一度だけ求める
+	closedStoreBytes, closedIndexBytes uint64
+	closedSizesKnown                   bool
+	// 封印済みのセグメントのストアの CRC-32。不明な場合は次に開いた時に求める
+	checksum      uint32
+	checksumKnown bool
+	// 封印時のストアのサイズ。起動時にファイルが MANIFEST より短くないか確かめる
+	storeBytes uint64
+	// 有効期限の最大値。開き直したセグメントは必要になるまで求めない
+	maxExpiresAt int64
+	expiryKnown  bool
+	// キー索引が無効の場合は nil
+	keys *keyIndex
 }
 
 func newSegment(dir string, baseOffset uint64, config Config) (*segment, error) {
 	s := &segment{
+		dir:        dir,
 		baseOffset: baseOffset,
 		config:     config,
 	}
-	storeFile, err := os.OpenFile(
-		filepath.Join(dir, fmt.Sprintf("%d%s", baseOffset, storeFileExtention)),
-		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+	if err := s.open(true); err != nil {
+		return nil, err
+	}
+	// 再起動後も封印時にストアを読み直さなくて済むよう、CRC を求めておく
+	if _, err := s.store.checksum(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if off, _, err := s.index.ReadLast(); err != nil {
+		s.nextOffset = baseOffset
+		s.expiryKnown = true
+	} else {
+		s.nextOffset = baseOffset + uint64(off) + 1
+	}
+	if err := s.buildKeyIndex(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 封印済みのセグメントをファイルを開かずに作る。次のオフセットは後続のセグメントのベースオフセット
+func newSealedSegment(dir string, baseOffset, nextOffset uint64, config Config) *segment {
+	return &segment{
+		dir:        dir,
+		baseOffset: baseOffset,
+		nextOffset: nextOffset,
+		config:     config,
+	}
+}
+
+// 封印済みのセグメントを開き直す場合はファイルを作らない。ファイルがなければエラーにする
+func (s *segment) open(create bool) error {
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+	// 既存のセグメントは作成時の設定で開く。設定変更でインデックスが切り詰められないように
+	if err := loadSegmentConfig(s.dir, s.baseOffset, &s.config); err != nil {
+		return err
+	}
+	if create {
+		if err := saveSegmentConfig(s.dir, s.baseOffset, s.config); err != nil {
+			return err
+		}
+	}
+	storeFile, err := s.config.fs().OpenFile(
+		segmentPath(s.dir, s.baseOffset, storeFileExtention),
+		flag|os.O_APPEND,
 		0600,
 	)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if s.store, err = newStore(storeFile); err != nil {
-		return nil, err
+	st, err := newStore(storeFile)
+	if err != nil {
+		storeFile.Close()
+		return err
 	}
 
-	indexFile, err := os.OpenFile(
-		filepath.Join(dir, fmt.Sprintf("%d%s", baseOffset, indexFileExtention)),
-		os.O_RDWR|os.O_CREATE,
+	indexFile, err := s.config.fs().OpenFile(
+		segmentPath(s.dir, s.baseOffset, indexFileExtention),
+		flag,
 		0600,
 	)
 	if err != nil {
-		return nil, err
+		st.Close()
+		return err
 	}
-	if s.index, err = newIndex(indexFile, config); err != nil {
-		return nil, err
+	idx, err := newIndex(indexFile, s.config)
+	if err != nil {
+		st.Close()
+		indexFile.Close()
+		return err
 	}
+	idx.recover(st.size)
+	s.store, s.index = st, idx
+	return nil
+}
 
-	if off, _, err := s.index.ReadLast(); err != nil {
-		s.nextOffset = baseOffset
-	} else {
-		s.nextOffset = baseOffset + uint64(off) + 1
+// アクティブでなくなったセグメントをディスクに同期し、チェックサムを記録する。
+// ログのロック中に呼ばれるので、切り詰めなどで CRC が不明ならストアは読まずに次に開いた時に求める
+func (s *segment) seal() error {
+	if err := s.Flush(); err != nil {
+		return err
 	}
-	return s, nil
+	s.checksum, s.checksumKnown = s.store.runningChecksum()
+	s.storeBytes = s.store.size
+	return nil
+}
+
+// 閉じている封印済みのセグメントを開き直す
+func (s *segment) reopen() error {
+	if err := s.open(false); err != nil {
+		return err
+	}
+	if err := s.buildKeyIndex(); err != nil {
+		s.Close()
+		return err
+	}
+	if !s.checksumKnown {
+		sum, err := s.store.checksum()
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.checksum, s.checksumKnown = sum, true
+	}
+	return nil
+}
+
+func (s *segment) isOpen() bool {
+	return s.store != nil
+}
+func segmentPath(dir string, baseOffset uint64, extention string) string {
+	return filepath.Join(dir, fmt.Sprintf("%d%s", baseOffset, extention))
+}
+
+func loadSegmentConfig(dir string, baseOffset uint64, config *Config) error {
+	b, err := config.fs().ReadFile(segmentPath(dir, baseOffset, configFileExtention))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &config.Segment)
+}
+
+func saveSegmentConfig(dir string, baseOffset uint64, config Config) error {
+	b, err := json.Marshal(config.Segment)
+	if err != nil {
+		return err
+	}
+	return config.fs().WriteFile(segmentPath(dir, baseOffset, configFileExtention), b, 0600)
 }
 
 func (s *segment) Append(record *api.Record) (offset uint64, err error) {
@@ -75,6 +200,10 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 	}
 
 	s.nextOffset++
+	s.updateExpiry(record)
+	if s.keys != nil && len(record.Key) > 0 {
+		s.keys.add(record.Key, current)
+	}
 	return current, nil
 }
 
@@ -92,6 +221,29 @@ func (s *segment) Read(offset uint64) (*api.Record, error) {
 	return record, err
 }
 
+// 指定したオフセット以降のレコードを切り捨てる
+func (s *segment) truncate(next uint64) error {
+	if next >= s.nextOffset {
+		return nil
+	}
+	rel := next - s.baseOffset
+	_, pos, err := s.index.Read(uint32(rel))
+	if err != nil {
+		return err
+	}
+	if err := s.store.truncate(pos); err != nil {
+		return err
+	}
+	s.index.truncate(rel)
+	s.nextOffset = next
+	s.maxExpiresAt, s.expiryKnown = 0, false
+	if s.keys != nil {
+		s.keys = nil
+		return s.buildKeyIndex()
+	}
+	return nil
+}
+
 func (s *segment) Flush() error {
 	if err := s.index.Flush(); err != nil {
 		return err
@@ -102,13 +254,23 @@ func (s *segment) Flush() error {
 	return nil
 }
 
+// 有効期限とキー索引のブルームフィルタは閉じた後も保持する。
+// キーごとのオフセットはメモリを使い続けないよう破棄し、開き直した時に作り直す
 func (s *segment) Close() error {
+	if !s.isOpen() {
+		return nil
+	}
 	if err := s.index.Close(); err != nil {
 		return err
 	}
 	if err := s.store.Close(); err != nil {
 		return err
 	}
+	s.closedStoreBytes, s.closedIndexBytes, s.closedSizesKnown = s.store.size, s.index.size, true
+	s.store, s.index = nil, nil
+	if s.keys != nil {
+		s.keys.offsets = nil
+	}
 	return nil
 }
 
@@ -116,15 +278,38 @@ func (s *segment) Remove() error {
 	if err := s.Close(); err != nil {
 		return err
 	}
-	if err := os.Remove(s.index.Name()); err != nil {
+	if err := s.config.fs().Remove(segmentPath(s.dir, s.baseOffset, indexFileExtention)); err != nil {
+		return err
+	}
+	if err := s.config.fs().Remove(segmentPath(s.dir, s.baseOffset, storeFileExtention)); err != nil {
 		return err
 	}
-	if err := os.Remove(s.store.Name()); err != nil {
+	if err := s.config.fs().Remove(segmentPath(s.dir, s.baseOffset, configFileExtention)); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	return nil
 }
 
+// 閉じているセグメントはファイルサイズから求める。閉じたインデックスは書き込み済みのサイズに切り詰められている
+func (s *segment) sizes() (storeBytes, indexBytes, indexFileBytes uint64, err error) {
+	if s.isOpen() {
+		return s.store.size, s.index.size, uint64(len(s.index.mmap)), nil
+	}
+	if !s.closedSizesKnown {
+		storeInfo, err := s.config.fs().Stat(segmentPath(s.dir, s.baseOffset, storeFileExtention))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		indexInfo, err := s.config.fs().Stat(segmentPath(s.dir, s.baseOffset, indexFileExtention))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		s.closedStoreBytes, s.closedIndexBytes = uint64(storeInfo.Size()), uint64(indexInfo.Size())
+		s.closedSizesKnown = true
+	}
+	return s.closedStoreBytes, s.closedIndexBytes, s.closedIndexBytes, nil
+}
+
 func (s *segment) IsMaxed() bool {
 	return s.store.size >= s.config.Segment.MaxStoreBytes ||
 		s.index.size >= s.config.Segment.MaxIndexBytes ||
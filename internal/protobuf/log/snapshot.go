@@ -0,0 +1,58 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+)
+
+// スナップショットが存在しない場合は false を返す
+func loadSnapshot(fs FS, dir, name string, v interface{}) (bool, error) {
+	b, err := fs.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(b, v)
+}
+
+// 一時ファイルに書いてからリネームすることで、書き込み途中のスナップショットを残さない
+func saveSnapshot(fs FS, dir, name string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, name+".tmp")
+	if err = fs.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return fs.Rename(tmp, filepath.Join(dir, name))
+}
+
+// from 以降のレコードを順に読み直す。スナップショット以降の状態復元に使う
+func (l *Log) replay(from uint64, fn func(record *api.Record)) error {
+	if lowest := l.segments[0].baseOffset; from < lowest {
+		from = lowest
+	}
+	for off := from; off < l.activeSegment.nextOffset; off++ {
+		s := l.getSegmentIfContains(off)
+		if s == nil {
+			continue
+		}
+		if err := l.acquire(s); err != nil {
+			return err
+		}
+		record, err := s.Read(off)
+		l.release(s)
+		if err != nil {
+			return err
+		}
+		fn(record)
+	}
+	return nil
+}
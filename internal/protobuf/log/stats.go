@@ -0,0 +1,62 @@
+package log
+
+type SegmentStats struct {
+	BaseOffset uint64
+	NextOffset uint64
+	StoreBytes uint64
+	// IndexBytes は書き込み済みのエントリのバイト数、IndexFileBytes はディスク上のサイズ
+	// (オープン中のインデックスは MaxIndexBytes まで拡張されている)
+	IndexBytes     uint64
+	IndexFileBytes uint64
+	Active         bool
+	StorePath      string
+	IndexPath      string
+}
+
+type Stats struct {
+	Segments      []SegmentStats
+	LowestOffset  uint64
+	HighestOffset uint64
+	// アクティブセグメントのインデックスの使用率 (0.0 - 1.0)
+	ActiveIndexUsage float64
+	TotalStoreBytes  uint64
+	TotalIndexBytes  uint64
+	// blob ファイルの合計。TotalDiskBytes にも含む
+	TotalBlobBytes uint64
+	TotalDiskBytes uint64
+}
+
+// 開いているセグメントはメモリ上の情報、閉じているセグメントはファイルサイズから集計する
+func (l *Log) Stats() Stats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	stats := Stats{
+		Segments:      make([]SegmentStats, 0, len(l.segments)),
+		HighestOffset: l.highestOffset(),
+	}
+	if len(l.segments) > 0 {
+		stats.LowestOffset = l.segments[0].baseOffset
+	}
+	for _, s := range l.segments {
+		ss := SegmentStats{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			Active:     s == l.activeSegment,
+			StorePath:  segmentPath(s.dir, s.baseOffset, storeFileExtention),
+			IndexPath:  segmentPath(s.dir, s.baseOffset, indexFileExtention),
+		}
+		// 閉じているセグメントのファイルが読めない場合はサイズを 0 とする
+		ss.StoreBytes, ss.IndexBytes, ss.IndexFileBytes, _ = l.cache.sizes(s)
+		if ss.Active && ss.IndexFileBytes > 0 {
+			stats.ActiveIndexUsage = float64(ss.IndexBytes) / float64(ss.IndexFileBytes)
+		}
+		stats.TotalStoreBytes += ss.StoreBytes
+		stats.TotalIndexBytes += ss.IndexBytes
+		stats.TotalDiskBytes += ss.StoreBytes + ss.IndexFileBytes
+		stats.Segments = append(stats.Segments, ss)
+	}
+	stats.TotalBlobBytes = l.blobs.bytes()
+	stats.TotalDiskBytes += stats.TotalBlobBytes
+	return stats
+}
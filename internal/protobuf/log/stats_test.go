@@ -0,0 +1,37 @@
+package log
+
+import (
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	l := newTestLog(t, 16, 0)
+	for i := 0; i < 3; i++ {
+		_, err := l.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	stats := l.Stats()
+	require.Len(t, stats.Segments, 3)
+	require.Equal(t, uint64(0), stats.LowestOffset)
+	require.Equal(t, uint64(2), stats.HighestOffset)
+
+	var storeBytes uint64
+	for i, s := range stats.Segments {
+		require.Equal(t, uint64(i), s.BaseOffset)
+		require.Equal(t, uint64(i+1), s.NextOffset)
+		require.Equal(t, entryWidth, s.IndexBytes)
+		require.Equal(t, l.conf.Segment.MaxIndexBytes, s.IndexFileBytes)
+		require.Equal(t, i == 2, s.Active)
+		require.NotEmpty(t, s.StorePath)
+		require.NotEmpty(t, s.IndexPath)
+		storeBytes += s.StoreBytes
+	}
+	require.Equal(t, storeBytes, stats.TotalStoreBytes)
+	require.Equal(t, 3*entryWidth, stats.TotalIndexBytes)
+	require.Equal(t, storeBytes+3*l.conf.Segment.MaxIndexBytes, stats.TotalDiskBytes)
+	require.InDelta(t, float64(entryWidth)/float64(l.conf.Segment.MaxIndexBytes), stats.ActiveIndexUsage, 0.0001)
+}
@@ -0,0 +1,88 @@
+package log
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// 空き容量の問い合わせ結果を使い回す期間
+const freeSpaceCacheDuration = time.Second
+
+type storageGuard struct {
+	freeBytes    uint64
+	checkedAt    time.Time
+	writtenBytes uint64 // 前回の問い合わせ以降に書き込んだバイト数
+}
+
+// 追記前に空き容量とログの容量上限を確認し、書き込む見込みのバイト数を返す。
+// 書き込み途中で失敗して壊れたレコードを残さないため
+func (l *Log) checkStorage(record *api.Record) (uint64, error) {
+	size := uint64(proto.Size(record)) + lenWidth + entryWidth
+	if l.activeSegment.IsMaxed() {
+		size += l.conf.Segment.MaxIndexBytes // 新しいセグメントのインデックス
+	}
+	return size, l.checkStorageFor(size)
+}
+
+func (l *Log) checkStorageFor(size uint64) error {
+	if max := l.conf.Storage.MaxLogBytes; max > 0 {
+		if used := l.diskBytes(); used+size > max {
+			return api.ErrStorageExhausted{
+				Reason: fmt.Sprintf("log size %d bytes exceeds quota %d bytes", used+size, max),
+			}
+		}
+	}
+	if min := l.conf.Storage.MinFreeBytes; min > 0 {
+		free, err := l.freeBytes()
+		if err != nil {
+			return err
+		}
+		if free < min+size {
+			return api.ErrStorageExhausted{
+				Reason: fmt.Sprintf("free space %d bytes is below threshold %d bytes", free, min),
+			}
+		}
+	}
+	return nil
+}
+
+func (l *Log) freeBytes() (uint64, error) {
+	g := &l.storage
+	r, ok := l.conf.fs().(freeSpaceReporter)
+	if !ok {
+		return math.MaxUint64, nil
+	}
+	if time.Since(g.checkedAt) > freeSpaceCacheDuration {
+		free, err := r.FreeBytes(l.dir)
+		if err != nil {
+			return 0, err
+		}
+		g.freeBytes = free
+		g.checkedAt = time.Now()
+		g.writtenBytes = 0
+	}
+	if g.writtenBytes >= g.freeBytes {
+		return 0, nil
+	}
+	return g.freeBytes - g.writtenBytes, nil
+}
+
+func (l *Log) diskBytes() uint64 {
+	var total uint64
+	for _, s := range l.segments {
+		storeBytes, _, indexFileBytes, _ := l.cache.sizes(s)
+		total += storeBytes + indexFileBytes
+	}
+	return total + l.blobs.bytes()
+}
+
+// 追記できない状態であればその理由を返す。ヘルスチェック用
+func (l *Log) CheckStorage() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.checkStorageFor(0)
+}
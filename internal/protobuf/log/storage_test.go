@@ -0,0 +1,56 @@
+package log
+
+import (
+	"os"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageQuota(t *testing.T) {
+	dir, err := os.MkdirTemp("", "storage_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Segment.MaxStoreBytes = 16
+	conf.Segment.MaxIndexBytes = entryWidth * 2
+	// 1レコードのセグメント (ストア23バイト + インデックス24バイト) 2つ分まで
+	conf.Storage.MaxLogBytes = 120
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+
+	record := &api.Record{Value: []byte("hello world")}
+	_, err = log.Append(record)
+	require.NoError(t, err)
+	_, err = log.Append(record)
+	require.NoError(t, err)
+	_, err = log.Append(record)
+	require.ErrorAs(t, err, &api.ErrStorageExhausted{})
+	require.Equal(t, uint64(1), log.HighestOffset())
+
+	// 古いセグメントを削除すれば追記を再開できる
+	require.NoError(t, log.Truncate(0))
+	off, err := log.Append(record)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+}
+
+func TestStorageMinFreeBytes(t *testing.T) {
+	dir, err := os.MkdirTemp("", "storage_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := Config{}
+	conf.Storage.MinFreeBytes = 1 << 62
+	log, err := NewLog(dir, conf)
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, err = log.Append(&api.Record{Value: []byte("hello world")})
+	require.ErrorAs(t, err, &api.ErrStorageExhausted{})
+	require.Equal(t, uint64(0), log.activeSegment.store.size)
+	require.Error(t, log.CheckStorage())
+}
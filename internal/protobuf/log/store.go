@@ -3,9 +3,8 @@ package log
 import (
 	"bufio"
 	"encoding/binary"
-	"io/fs"
-	"io/ioutil"
-	"os"
+	"hash/crc32"
+	"io"
 	"sync"
 )
 
@@ -18,28 +17,28 @@ const (
 )
 
 type store struct {
-	*os.File
+	File
 	mu   sync.Mutex
 	buf  *bufio.Writer
 	size uint64
+	// 書き込んだ内容の CRC-32。開き直したストアは必要になった時にファイルから求める
+	crc      uint32
+	crcKnown bool
 }
 
-func newStore(f *os.File) (*store, error) {
-	fileInfo, err := getFileInfoIfExists(f.Name())
+func newStore(f File) (*store, error) {
+	fileInfo, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
 	return &store{
-		File: f,
-		size: uint64(fileInfo.Size()),
-		buf:  bufio.NewWriter(f),
+		File:     f,
+		size:     uint64(fileInfo.Size()),
+		buf:      bufio.NewWriter(f),
+		crcKnown: fileInfo.Size() == 0,
 	}, nil
 }
 
-func getFileInfoIfExists(fileName string) (fs.FileInfo, error) {
-	return os.Stat(fileName)
-}
-
 func (s *store) Append(p []byte) (num uint64, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -52,6 +51,12 @@ func (s *store) Append(p []byte) (num uint64, pos uint64, err error) {
 	if err != nil {
 		return 0, 0, err
 	}
+	if s.crcKnown {
+		var size [lenWidth]byte
+		enc.PutUint64(size[:], uint64(len(p)))
+		s.crc = crc32.Update(s.crc, crc32.IEEETable, size[:])
+		s.crc = crc32.Update(s.crc, crc32.IEEETable, p)
+	}
 
 	w += lenWidth
 	s.size += uint64(w)
@@ -87,6 +92,48 @@ func (s *store) ReadAt(p []byte, offset int64) (int, error) {
 	return s.File.ReadAt(p, offset)
 }
 
+// 書き込みながら求めた CRC-32 を返す。切り詰めた後などで不明なら ok は false
+func (s *store) runningChecksum() (crc uint32, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.crc, s.crcKnown
+}
+
+// 不明な場合はファイル全体を読んで求める
+func (s *store) checksum() (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return 0, err
+	}
+	if !s.crcKnown {
+		b, err := io.ReadAll(io.NewSectionReader(s.File, 0, int64(s.size)))
+		if err != nil {
+			return 0, err
+		}
+		s.crc = crc32.ChecksumIEEE(b)
+		s.crcKnown = true
+	}
+	return s.crc, nil
+}
+
+// 指定した位置以降を切り捨てる
+func (s *store) truncate(size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Truncate(int64(size)); err != nil {
+		return err
+	}
+	s.size = size
+	s.crc, s.crcKnown = 0, size == 0
+	return nil
+}
+
 func (s *store) Flush() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -95,7 +142,7 @@ func (s *store) Flush() error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return s.File.Sync()
 }
 
 func (s *store) Close() error {
@@ -105,12 +152,9 @@ func (s *store) Close() error {
 	return s.File.Close()
 }
 
-func (s *store) getFileSize() (size int64, err error) {
-	f, err := os.OpenFile(s.File.Name(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
-	if err != nil {
-		return 0, err
-	}
-	fi, err := f.Stat()
+// 閉じた後も求められるよう、ファイルを開いた FS で調べる
+func (s *store) getFileSize(fs FS) (size int64, err error) {
+	fi, err := fs.Stat(s.File.Name())
 	if err != nil {
 		return 0, err
 	}
@@ -118,7 +162,11 @@ func (s *store) getFileSize() (size int64, err error) {
 }
 
 func (s *store) readAll() (lines string, err error) {
-	fileContent, err := ioutil.ReadFile(s.File.Name())
+	fi, err := s.File.Stat()
+	if err != nil {
+		return "", err
+	}
+	fileContent, err := io.ReadAll(io.NewSectionReader(s.File, 0, fi.Size()))
 	if err != nil {
 		return "", err
 	}
@@ -79,13 +79,13 @@ func TestStoreClose(t *testing.T) {
 	_, _, err = s.Append(writeData)
 	require.NoError(t, err)
 
-	beforeSize, err := s.getFileSize()
+	beforeSize, err := s.getFileSize(OSFS{})
 	require.NoError(t, err)
 
 	err = s.Close()
 	require.NoError(t, err)
 
-	afterSize, err := s.getFileSize()
+	afterSize, err := s.getFileSize(OSFS{})
 	require.NoError(t, err)
 	require.True(t, afterSize > beforeSize)
 
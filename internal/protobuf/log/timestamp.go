@@ -0,0 +1,63 @@
+package log
+
+import (
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+)
+
+// 追記時刻が未設定のレコードに設定する。インポートしたレコードは元の時刻を保つ。
+// 未来の時刻は以降の全レコードの時刻を止めてしまうので現在時刻に抑える。
+// 時刻で二分探索できるよう、直前のレコード (prev) より前の時刻は直前のレコードの時刻に揃える
+func setTimestamp(record *api.Record, now time.Time, prev int64) {
+	if ms := now.UnixMilli(); record.Timestamp == 0 || record.Timestamp > ms {
+		record.Timestamp = ms
+	}
+	if record.Timestamp < prev {
+		record.Timestamp = prev
+	}
+}
+
+// 最後のレコードの追記時刻を読み直す。起動時と末尾を切り捨てた後に呼ぶ
+func (l *Log) restoreLastTimestamp() error {
+	l.lastTimestamp = 0
+	next := l.activeSegment.nextOffset
+	if next == l.segments[0].baseOffset {
+		return nil
+	}
+	record, err := l.read(next - 1)
+	if err != nil {
+		return err
+	}
+	l.lastTimestamp = record.Timestamp
+	return nil
+}
+
+// 追記時刻が timestamp 以降の最初のオフセットを返す。なければ次に追記されるオフセットを返す。
+// 追記時刻は setTimestamp でオフセット順に減らないよう揃えているので二分探索する
+func (l *Log) OffsetForTime(timestamp int64) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	lo, hi := l.segments[0].baseOffset, l.activeSegment.nextOffset
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		record, err := l.read(mid)
+		if err != nil {
+			return 0, err
+		}
+		if record.Timestamp < timestamp {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// 残っている最も古いオフセットと次に追記されるオフセットを返す
+func (l *Log) Offsets() (low, high uint64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.segments[0].baseOffset, l.activeSegment.nextOffset
+}
@@ -0,0 +1,111 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetForTime(t *testing.T) {
+	fs := NewMemFS()
+	require.NoError(t, fs.MkdirAll("/log", 0700))
+	log, err := NewLog("/log", newMemConfig(fs))
+	require.NoError(t, err)
+	defer log.Close()
+
+	for _, ts := range []int64{100, 200, 200, 300, 400} {
+		_, err := log.Append(&api.Record{Value: []byte("value"), Timestamp: ts})
+		require.NoError(t, err)
+	}
+	// 未設定の場合は追記時刻を設定する
+	record := &api.Record{Value: []byte("value")}
+	_, err = log.Append(record)
+	require.NoError(t, err)
+	require.NotZero(t, record.Timestamp)
+
+	for senario, tc := range map[string]struct {
+		timestamp int64
+		want      uint64
+	}{
+		"before first record": {timestamp: 50, want: 0},
+		"exact match":         {timestamp: 200, want: 1},
+		"between records":     {timestamp: 250, want: 3},
+		"after last record":   {timestamp: record.Timestamp + 1, want: 6},
+	} {
+		t.Run(senario, func(t *testing.T) {
+			off, err := log.OffsetForTime(tc.timestamp)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, off)
+		})
+	}
+
+	require.NoError(t, log.Truncate(2))
+	low, high := log.Offsets()
+	require.Greater(t, low, uint64(0))
+	require.Equal(t, uint64(6), high)
+	off, err := log.OffsetForTime(50)
+	require.NoError(t, err)
+	require.Equal(t, low, off)
+}
+
+func TestTimestampNeverDecreases(t *testing.T) {
+	fs := NewMemFS()
+	require.NoError(t, fs.MkdirAll("/log", 0700))
+	conf := newMemConfig(fs)
+	log, err := NewLog("/log", conf)
+	require.NoError(t, err)
+
+	// 直前のレコードより前の時刻は直前のレコードの時刻に揃える
+	for _, ts := range []int64{100, 300, 200} {
+		_, err := log.Append(&api.Record{Value: []byte("value"), Timestamp: ts})
+		require.NoError(t, err)
+	}
+	record, err := log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, int64(300), record.Timestamp)
+
+	batch := []*api.Record{{Value: []byte("a"), Timestamp: 250}, {Value: []byte("b"), Timestamp: 400}}
+	_, err = log.AppendBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, int64(300), batch[0].Timestamp)
+	require.Equal(t, int64(400), batch[1].Timestamp)
+
+	off, err := log.OffsetForTime(250)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+
+	t.Run("restored after reopen", func(t *testing.T) {
+		require.NoError(t, log.Close())
+		log, err = NewLog("/log", conf)
+		require.NoError(t, err)
+		record := &api.Record{Value: []byte("value"), Timestamp: 350}
+		_, err := log.Append(record)
+		require.NoError(t, err)
+		require.Equal(t, int64(400), record.Timestamp)
+	})
+
+	t.Run("restored after truncate", func(t *testing.T) {
+		require.NoError(t, log.TruncateAfter(1))
+		record := &api.Record{Value: []byte("value"), Timestamp: 250}
+		_, err := log.Append(record)
+		require.NoError(t, err)
+		require.Equal(t, int64(300), record.Timestamp)
+	})
+
+	t.Run("future timestamp is capped", func(t *testing.T) {
+		future := &api.Record{Value: []byte("future"), Timestamp: time.Now().Add(time.Hour).UnixMilli()}
+		_, err := log.Append(future)
+		require.NoError(t, err)
+		require.LessOrEqual(t, future.Timestamp, time.Now().UnixMilli())
+		// 未来の時刻に以降のレコードの時刻が揃えられない
+		time.Sleep(5 * time.Millisecond)
+		next := &api.Record{Value: []byte("next")}
+		_, err = log.Append(next)
+		require.NoError(t, err)
+		require.Greater(t, next.Timestamp, future.Timestamp)
+	})
+
+	require.NoError(t, log.Close())
+}
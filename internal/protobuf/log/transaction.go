@@ -0,0 +1,253 @@
+package log
+
+import (
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+)
+
+const (
+	transactionSnapshotFile   = "transactions.snapshot"
+	defaultTransactionTimeout = time.Minute
+)
+
+type openTransaction struct {
+	FirstOffset uint64 `json:"first_offset"`
+	HasRecords  bool   `json:"has_records"`
+	lastUpdated time.Time
+}
+
+// アボートされたトランザクションのオフセット範囲 (abort index)
+type abortedTransaction struct {
+	TransactionId string `json:"transaction_id"`
+	FirstOffset   uint64 `json:"first_offset"`
+	LastOffset    uint64 `json:"last_offset"` // アボートの制御レコードのオフセット
+}
+
+type transactions struct {
+	// スナップショットに反映済みの次のオフセット
+	NextOffset uint64                      `json:"next_offset"`
+	Open       map[string]*openTransaction `json:"open"`
+	Aborted    []abortedTransaction        `json:"aborted"`
+}
+
+func newTransactions() *transactions {
+	return &transactions{Open: map[string]*openTransaction{}}
+}
+
+func (t *transactions) apply(record *api.Record, now time.Time) {
+	if record.TransactionId == "" {
+		return
+	}
+	txn, ok := t.Open[record.TransactionId]
+	switch record.Control {
+	case api.ControlType_CONTROL_NONE:
+		if !ok {
+			txn = &openTransaction{}
+			t.Open[record.TransactionId] = txn
+		}
+		if !txn.HasRecords {
+			txn.FirstOffset = record.Offset
+			txn.HasRecords = true
+		}
+		txn.lastUpdated = now
+	case api.ControlType_CONTROL_COMMIT:
+		delete(t.Open, record.TransactionId)
+	case api.ControlType_CONTROL_ABORT:
+		first := record.Offset
+		if ok && txn.HasRecords {
+			first = txn.FirstOffset
+		}
+		t.Aborted = append(t.Aborted, abortedTransaction{
+			TransactionId: record.TransactionId,
+			FirstOffset:   first,
+			LastOffset:    record.Offset,
+		})
+		delete(t.Open, record.TransactionId)
+	}
+}
+
+func (t *transactions) isAborted(record *api.Record) bool {
+	if record.TransactionId == "" {
+		return false
+	}
+	for _, a := range t.Aborted {
+		if a.TransactionId == record.TransactionId &&
+			a.FirstOffset <= record.Offset && record.Offset <= a.LastOffset {
+			return true
+		}
+	}
+	return false
+}
+
+// 未完了のトランザクションのうち最も古いレコードのオフセット。これ以降は read_committed で読めない
+func (t *transactions) lastStableOffset(nextOffset uint64) uint64 {
+	lso := nextOffset
+	for _, txn := range t.Open {
+		if txn.HasRecords && txn.FirstOffset < lso {
+			lso = txn.FirstOffset
+		}
+	}
+	return lso
+}
+
+// 切り捨てたオフセット以降の記録を消す。アボートの制御レコードを切り捨てたトランザクションは
+// 未完了に戻し、タイムアウトで改めてアボートさせる
+func (t *transactions) truncate(next uint64, now time.Time) {
+	for _, txn := range t.Open {
+		if txn.HasRecords && txn.FirstOffset >= next {
+			txn.HasRecords = false
+		}
+	}
+	var aborted []abortedTransaction
+	for _, a := range t.Aborted {
+		switch {
+		case a.LastOffset < next:
+			aborted = append(aborted, a)
+		case a.FirstOffset < next:
+			t.Open[a.TransactionId] = &openTransaction{
+				FirstOffset: a.FirstOffset,
+				HasRecords:  true,
+				lastUpdated: now,
+			}
+		}
+	}
+	t.Aborted = aborted
+}
+
+func (t *transactions) save(fs FS, dir string, lowestOffset, nextOffset uint64) error {
+	// 削除済みのセグメントを指すアボート情報は不要
+	var aborted []abortedTransaction
+	for _, a := range t.Aborted {
+		if a.LastOffset >= lowestOffset {
+			aborted = append(aborted, a)
+		}
+	}
+	t.Aborted = aborted
+	t.NextOffset = nextOffset
+	return saveSnapshot(fs, dir, transactionSnapshotFile, t)
+}
+
+func (l *Log) restoreTransactions() error {
+	t := newTransactions()
+	if _, err := loadSnapshot(l.conf.fs(), l.dir, transactionSnapshotFile, t); err != nil {
+		return err
+	}
+	// 再起動前の経過時間は分からないため、タイムアウトは再起動時点から数える
+	now := time.Now()
+	for _, txn := range t.Open {
+		txn.lastUpdated = now
+	}
+	if err := l.replay(t.NextOffset, func(record *api.Record) {
+		t.apply(record, now)
+	}); err != nil {
+		return err
+	}
+	l.txns = t
+	return nil
+}
+
+func (l *Log) BeginTransaction(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	txn, ok := l.txns.Open[id]
+	if !ok {
+		txn = &openTransaction{}
+		l.txns.Open[id] = txn
+	}
+	txn.lastUpdated = time.Now()
+	return nil
+}
+
+// コミットの制御レコードを書き込み、そのオフセットを返す
+func (l *Log) CommitTransaction(id string) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.endTransaction(id, api.ControlType_CONTROL_COMMIT)
+}
+
+// アボートの制御レコードを書き込み、そのオフセットを返す
+func (l *Log) AbortTransaction(id string) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.endTransaction(id, api.ControlType_CONTROL_ABORT)
+}
+
+func (l *Log) endTransaction(id string, control api.ControlType) (uint64, error) {
+	if _, ok := l.txns.Open[id]; !ok {
+		return 0, api.ErrTransactionNotFound{TransactionId: id}
+	}
+	record := &api.Record{TransactionId: id, Control: control}
+	setTimestamp(record, time.Now(), l.lastTimestamp)
+	return l.append(record)
+}
+
+// タイムアウトしたトランザクションをアボートする。プロデューサーがいなくなった場合の後始末
+func (l *Log) AbortExpiredTransactions(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.abortExpiredTransactions(now)
+}
+
+func (l *Log) abortExpiredTransactions(now time.Time) error {
+	for id, txn := range l.txns.Open {
+		if now.Sub(txn.lastUpdated) < l.conf.Transaction.Timeout {
+			continue
+		}
+		if _, err := l.endTransaction(id, api.ControlType_CONTROL_ABORT); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Log) startTransactionReaper() {
+	stop := l.stopReaper
+	interval := l.conf.Transaction.Timeout / 2
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				l.mu.Lock()
+				select {
+				case <-stop: // Close 済み
+				default:
+					l.abortExpiredTransactions(now)
+				}
+				l.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// read_committed での読み出し。アボート済みのレコードと制御レコードは ErrRecordFiltered を返す
+func (l *Log) ReadCommitted(offset uint64) (*api.Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if offset >= l.txns.lastStableOffset(l.activeSegment.nextOffset) {
+		return nil, api.ErrOffsetOutOfRange{Offset: offset}
+	}
+	record, err := l.read(offset)
+	if err != nil {
+		return nil, err
+	}
+	if record.Control != api.ControlType_CONTROL_NONE || l.txns.isAborted(record) {
+		return nil, api.ErrRecordFiltered{Offset: offset}
+	}
+	if isExpired(record, time.Now()) {
+		return nil, api.ErrRecordExpired{Offset: offset}
+	}
+	return record, nil
+}
+
+func (l *Log) LastStableOffset() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.txns.lastStableOffset(l.activeSegment.nextOffset)
+}
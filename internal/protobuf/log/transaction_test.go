@@ -0,0 +1,137 @@
+package log
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransaction(t *testing.T) {
+	for senario, fn := range map[string]func(*testing.T, *Log){
+		"commit":                     testTransactionCommit,
+		"abort":                      testTransactionAbort,
+		"unknown transaction fails":  testTransactionNotFound,
+		"timeout aborts transaction": testTransactionTimeout,
+	} {
+		t.Run(senario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "transaction_test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			conf := Config{}
+			conf.Segment.MaxStoreBytes = 1024
+			conf.Transaction.Timeout = 50 * time.Millisecond
+			log, err := NewLog(dir, conf)
+			require.NoError(t, err)
+
+			fn(t, log)
+			require.NoError(t, log.Close())
+		})
+	}
+}
+
+func appendTxn(t *testing.T, log *Log, id string, value string) uint64 {
+	t.Helper()
+	off, err := log.Append(&api.Record{Value: []byte(value), TransactionId: id})
+	require.NoError(t, err)
+	return off
+}
+
+func testTransactionCommit(t *testing.T, log *Log) {
+	require.NoError(t, log.BeginTransaction("txn"))
+	first := appendTxn(t, log, "txn", "first")
+	plain, err := log.Append(&api.Record{Value: []byte("plain")})
+	require.NoError(t, err)
+	second := appendTxn(t, log, "txn", "second")
+
+	// 未完了のトランザクション以降は read_committed では読めない
+	require.Equal(t, first, log.LastStableOffset())
+	_, err = log.ReadCommitted(plain)
+	require.ErrorAs(t, err, &api.ErrOffsetOutOfRange{})
+	_, err = log.Read(plain)
+	require.NoError(t, err)
+
+	marker, err := log.CommitTransaction("txn")
+	require.NoError(t, err)
+	require.Equal(t, marker+1, log.LastStableOffset())
+
+	for _, off := range []uint64{first, plain, second} {
+		_, err := log.ReadCommitted(off)
+		require.NoError(t, err)
+	}
+	_, err = log.ReadCommitted(marker)
+	require.ErrorAs(t, err, &api.ErrRecordFiltered{})
+}
+
+func testTransactionAbort(t *testing.T, log *Log) {
+	require.NoError(t, log.BeginTransaction("txn"))
+	aborted := appendTxn(t, log, "txn", "aborted")
+	_, err := log.AbortTransaction("txn")
+	require.NoError(t, err)
+
+	_, err = log.ReadCommitted(aborted)
+	require.ErrorAs(t, err, &api.ErrRecordFiltered{})
+	_, err = log.Read(aborted)
+	require.NoError(t, err)
+
+	// 同じIDで次のトランザクションを開始できる
+	require.NoError(t, log.BeginTransaction("txn"))
+	committed := appendTxn(t, log, "txn", "committed")
+	_, err = log.CommitTransaction("txn")
+	require.NoError(t, err)
+	_, err = log.ReadCommitted(committed)
+	require.NoError(t, err)
+}
+
+func testTransactionNotFound(t *testing.T, log *Log) {
+	_, err := log.Append(&api.Record{Value: []byte("value"), TransactionId: "unknown"})
+	require.ErrorAs(t, err, &api.ErrTransactionNotFound{})
+	_, err = log.CommitTransaction("unknown")
+	require.ErrorAs(t, err, &api.ErrTransactionNotFound{})
+	_, err = log.Append(&api.Record{Control: api.ControlType_CONTROL_COMMIT})
+	require.ErrorAs(t, err, &api.ErrInvalidRecord{})
+}
+
+func testTransactionTimeout(t *testing.T, log *Log) {
+	require.NoError(t, log.BeginTransaction("txn"))
+	off := appendTxn(t, log, "txn", "value")
+
+	require.Eventually(t, func() bool {
+		_, err := log.ReadCommitted(off)
+		_, filtered := err.(api.ErrRecordFiltered)
+		return filtered
+	}, time.Second, 10*time.Millisecond)
+	_, err := log.Append(&api.Record{Value: []byte("value"), TransactionId: "txn"})
+	require.ErrorAs(t, err, &api.ErrTransactionNotFound{})
+}
+
+func TestTransactionRestore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "transaction_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	require.NoError(t, log.BeginTransaction("aborted"))
+	aborted := appendTxn(t, log, "aborted", "value")
+	_, err = log.AbortTransaction("aborted")
+	require.NoError(t, err)
+	require.NoError(t, log.BeginTransaction("open"))
+	open := appendTxn(t, log, "open", "value")
+	require.NoError(t, log.Close())
+
+	restored, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	_, err = restored.ReadCommitted(aborted)
+	require.ErrorAs(t, err, &api.ErrRecordFiltered{})
+	require.Equal(t, open, restored.LastStableOffset())
+	_, err = restored.CommitTransaction("open")
+	require.NoError(t, err)
+	_, err = restored.ReadCommitted(open)
+	require.NoError(t, err)
+}
@@ -0,0 +1,233 @@
+package offset
+
+import (
+	"errors"
+	"fmt"
+	stdlog "log"
+	"os"
+	"sync"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	compactingDirSuffix = ".compacting"
+	oldDirSuffix        = ".old"
+	// 最新値以外のレコードがこの件数を超えたらコンパクションする
+	compactThreshold = 1000
+)
+
+type key struct {
+	group, topic string
+}
+
+// コンシューマーグループごとのコミット済みオフセットを内部ログに保存する。
+// ログには追記のみ行い、同じキーの古いレコードはコンパクションで取り除く
+type Store struct {
+	mu      sync.Mutex
+	dir     string
+	conf    log.Config
+	log     *log.Log
+	offsets map[key]uint64
+	records uint64
+	// コンパクションはコミットとは別に裏で行う。失敗したらさらに compactThreshold 件書くまで再試行しない
+	compacting bool
+	retryAt    uint64
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+func New(dir string, conf log.Config) (*Store, error) {
+	s := &Store{
+		dir:  dir,
+		conf: conf,
+	}
+	if err := s.recoverCompaction(); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// コンパクションの途中で終了していた場合に、完全な方のログを残す
+func (s *Store) recoverCompaction() error {
+	tmp := s.dir + compactingDirSuffix
+	if _, err := os.Stat(s.dir); errors.Is(err, os.ErrNotExist) {
+		// 元のログを退避した後なので、書き出し済みのログに差し替える
+		if _, err := os.Stat(tmp); err == nil {
+			if err = os.Rename(tmp, s.dir); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.dir + oldDirSuffix)
+}
+
+func (s *Store) open() error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	l, err := log.NewLog(s.dir, s.conf)
+	if err != nil {
+		return err
+	}
+	s.log = l
+	s.offsets = map[key]uint64{}
+	s.records = 0
+	for off := l.LowestOffset(); ; off++ {
+		record, err := l.Read(off)
+		if errors.As(err, &api.ErrOffsetOutOfRange{}) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		commit := &api.OffsetCommit{}
+		if err = proto.Unmarshal(record.Value, commit); err != nil {
+			return err
+		}
+		s.offsets[key{commit.Group, commit.Topic}] = commit.Offset
+		s.records++
+	}
+	return nil
+}
+
+func (s *Store) Commit(group, topic string, offset uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(&api.OffsetCommit{Group: group, Topic: topic, Offset: offset}); err != nil {
+		return err
+	}
+	s.offsets[key{group, topic}] = offset
+	// 書き込めた時点でコミットは成功しているので、コンパクションの結果は返さない
+	if !s.compacting && s.records >= s.retryAt && s.records-uint64(len(s.offsets)) > compactThreshold {
+		s.compacting = true
+		s.wg.Add(1)
+		go s.compactInBackground()
+	}
+	return nil
+}
+
+func (s *Store) compactInBackground() {
+	defer s.wg.Done()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.compacting = false
+	if s.closed {
+		return
+	}
+	if err := s.compact(); err != nil {
+		s.retryAt = s.records + compactThreshold
+		stdlog.Printf("compact offset log %s: %v", s.dir, err)
+	}
+}
+
+// コミット済みのオフセットを返す。コミットされていない場合は false を返す
+func (s *Store) Fetch(group, topic string) (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset, ok := s.offsets[key{group, topic}]
+	return offset, ok, nil
+}
+
+func (s *Store) append(commit *api.OffsetCommit) error {
+	b, err := proto.Marshal(commit)
+	if err != nil {
+		return err
+	}
+	if _, err = s.log.Append(&api.Record{Value: b}); err != nil {
+		return err
+	}
+	s.records++
+	// コミットを返した後に失われないよう、ディスクまで書き出す
+	return s.log.Flush()
+}
+
+// 最新値だけを新しいログに書き出して差し替える
+func (s *Store) compact() error {
+	tmp := s.dir + compactingDirSuffix
+	if err := s.writeCompacted(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := s.log.Close(); err != nil {
+		return err
+	}
+	if err := s.swap(tmp); err != nil {
+		// 閉じたログのままにしないよう、元のログを開き直す
+		if rerr := s.restore(tmp); rerr != nil {
+			return fmt.Errorf("%v: reopen offset log: %w", err, rerr)
+		}
+		return err
+	}
+	return s.open()
+}
+
+func (s *Store) writeCompacted(tmp string) error {
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return err
+	}
+	compacted, err := log.NewLog(tmp, s.conf)
+	if err != nil {
+		return err
+	}
+	for k, offset := range s.offsets {
+		b, err := proto.Marshal(&api.OffsetCommit{Group: k.group, Topic: k.topic, Offset: offset})
+		if err != nil {
+			compacted.Close()
+			return err
+		}
+		if _, err = compacted.Append(&api.Record{Value: b}); err != nil {
+			compacted.Close()
+			return err
+		}
+	}
+	return compacted.Close()
+}
+
+func (s *Store) swap(tmp string) error {
+	old := s.dir + oldDirSuffix
+	if err := os.Rename(s.dir, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// 差し替えに失敗したときに、退避した元のログを戻して開き直す
+func (s *Store) restore(tmp string) error {
+	if _, err := os.Stat(s.dir); errors.Is(err, os.ErrNotExist) {
+		if err = os.Rename(s.dir+oldDirSuffix, s.dir); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// 実行中のコンパクションの終了を待ってから返す
+func (s *Store) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	err := s.log.Close()
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
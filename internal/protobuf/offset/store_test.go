@@ -0,0 +1,112 @@
+package offset
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	root, err := os.MkdirTemp("", "offset_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "offsets")
+
+	s, err := New(dir, log.Config{})
+	require.NoError(t, err)
+
+	_, found, err := s.Fetch("group", "topic")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, s.Commit("group", "topic", 1))
+	require.NoError(t, s.Commit("group", "topic", 5))
+	require.NoError(t, s.Commit("other", "topic", 3))
+
+	offset, found, err := s.Fetch("group", "topic")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(5), offset)
+
+	t.Run("restore on restart", func(t *testing.T) {
+		require.NoError(t, s.Close())
+		s, err = New(dir, log.Config{})
+		require.NoError(t, err)
+
+		offset, found, err := s.Fetch("group", "topic")
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, uint64(5), offset)
+		offset, _, err = s.Fetch("other", "topic")
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), offset)
+	})
+
+	t.Run("compaction keeps latest offsets", func(t *testing.T) {
+		for i := uint64(0); i < compactThreshold; i++ {
+			require.NoError(t, s.Commit("group", "topic", 10+i))
+		}
+		s.wg.Wait()
+		require.Equal(t, uint64(2), s.records)
+		require.NoDirExists(t, dir+compactingDirSuffix)
+		require.NoDirExists(t, dir+oldDirSuffix)
+
+		require.NoError(t, s.Close())
+		s, err = New(dir, log.Config{})
+		require.NoError(t, err)
+		offset, _, err := s.Fetch("group", "topic")
+		require.NoError(t, err)
+		require.Equal(t, uint64(10+compactThreshold-1), offset)
+		offset, _, err = s.Fetch("other", "topic")
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), offset)
+	})
+
+	t.Run("recover interrupted compaction", func(t *testing.T) {
+		require.NoError(t, s.Close())
+		// 元のログを退避した直後に終了した状態
+		require.NoError(t, os.Rename(dir, dir+compactingDirSuffix))
+		s, err = New(dir, log.Config{})
+		require.NoError(t, err)
+		offset, found, err := s.Fetch("other", "topic")
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, uint64(3), offset)
+	})
+
+	t.Run("failed compaction reopens log", func(t *testing.T) {
+		// 退避先に空でないディレクトリがあると元のログを退避できない
+		old := dir + oldDirSuffix
+		require.NoError(t, os.MkdirAll(filepath.Join(old, "blocker"), 0700))
+		defer os.RemoveAll(old)
+
+		s.mu.Lock()
+		err := s.compact()
+		s.mu.Unlock()
+		require.Error(t, err)
+		require.NoDirExists(t, dir+compactingDirSuffix)
+
+		require.NoError(t, s.Commit("other", "topic", 4))
+		offset, _, err := s.Fetch("other", "topic")
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), offset)
+
+		// 裏で行うコンパクションに失敗してもコミットは成功する
+		s.mu.Lock()
+		s.records += compactThreshold
+		s.mu.Unlock()
+		require.NoError(t, s.Commit("other", "topic", 5))
+		s.wg.Wait()
+		require.NotZero(t, s.retryAt)
+		require.NoDirExists(t, dir+compactingDirSuffix)
+		offset, _, err = s.Fetch("other", "topic")
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), offset)
+		require.NoError(t, s.Commit("other", "topic", 6))
+	})
+
+	require.NoError(t, s.Close())
+}
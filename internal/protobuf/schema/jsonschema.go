@@ -0,0 +1,79 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+type jsonSchema struct {
+	schema *gojsonschema.Schema
+	doc    jsonSchemaDocument
+}
+
+// 互換性の判定に使うトップレベルの定義
+type jsonSchemaDocument struct {
+	Properties           map[string]map[string]interface{} `json:"properties"`
+	Required             []string                          `json:"required"`
+	AdditionalProperties interface{}                       `json:"additionalProperties"`
+}
+
+func compileJSON(definition []byte) (*jsonSchema, error) {
+	s, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(definition))
+	if err != nil {
+		return nil, err
+	}
+	js := &jsonSchema{schema: s}
+	if err = json.Unmarshal(definition, &js.doc); err != nil {
+		return nil, err
+	}
+	return js, nil
+}
+
+func (j *jsonSchema) validate(value []byte) []string {
+	result, err := j.schema.Validate(gojsonschema.NewBytesLoader(value))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var violations []string
+	for _, e := range result.Errors() {
+		violations = append(violations, e.String())
+	}
+	return violations
+}
+
+func (j *jsonSchema) canRead(writer *jsonSchema) []string {
+	var reasons []string
+	writerRequired := map[string]bool{}
+	for _, p := range writer.doc.Required {
+		writerRequired[p] = true
+	}
+	for _, p := range j.doc.Required {
+		if !writerRequired[p] {
+			reasons = append(reasons, fmt.Sprintf("property %q is required but may be missing in the data", p))
+		}
+	}
+
+	names := make([]string, 0, len(writer.doc.Properties))
+	for name := range writer.doc.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rp, ok := j.doc.Properties[name]
+		if !ok {
+			if j.doc.AdditionalProperties == false {
+				reasons = append(reasons, fmt.Sprintf("property %q is not allowed", name))
+			}
+			continue
+		}
+		if !reflect.DeepEqual(rp["type"], writer.doc.Properties[name]["type"]) {
+			reasons = append(reasons, fmt.Sprintf("property %q changed type from %v to %v",
+				name, writer.doc.Properties[name]["type"], rp["type"]))
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,79 @@
+package schema
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type protoSchema struct {
+	desc protoreflect.MessageDescriptor
+}
+
+func compileProto(definition []byte, messageName string) (*protoSchema, error) {
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(definition, fds); err != nil {
+		return nil, err
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", messageName)
+	}
+	return &protoSchema{desc: md}, nil
+}
+
+// スキーマに定義されていないフィールドは、互換性の判定と同じく新しいスキーマで追加されたフィールドとして許容する
+func (p *protoSchema) validate(value []byte) []string {
+	msg := dynamicpb.NewMessage(p.desc)
+	if err := proto.Unmarshal(value, msg); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+func (p *protoSchema) canRead(writer *protoSchema) []string {
+	return compareMessages(p.desc, writer.desc, map[protoreflect.FullName]bool{})
+}
+
+// 同じフィールド番号の型が変わっていないことを確認する。追加・削除されたフィールドは未知のフィールドとして扱われるので互換
+func compareMessages(reader, writer protoreflect.MessageDescriptor, visited map[protoreflect.FullName]bool) []string {
+	if visited[reader.FullName()] {
+		return nil
+	}
+	visited[reader.FullName()] = true
+
+	var reasons []string
+	fields := writer.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		wf := fields.Get(i)
+		rf := reader.Fields().ByNumber(wf.Number())
+		if rf == nil {
+			continue
+		}
+		name := fmt.Sprintf("%s.%s (%d)", reader.FullName(), rf.Name(), rf.Number())
+		if rf.Kind() != wf.Kind() {
+			reasons = append(reasons, fmt.Sprintf("%s changed type from %s to %s", name, wf.Kind(), rf.Kind()))
+			continue
+		}
+		if rf.Cardinality() != wf.Cardinality() || rf.IsMap() != wf.IsMap() {
+			reasons = append(reasons, fmt.Sprintf("%s changed cardinality", name))
+			continue
+		}
+		if rf.Message() != nil && wf.Message() != nil {
+			reasons = append(reasons, compareMessages(rf.Message(), wf.Message(), visited)...)
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,242 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	registryFile         = "schemas.json"
+	defaultCompatibility = api.Compatibility_COMPATIBILITY_BACKWARD
+)
+
+type storedSchema struct {
+	Version     uint32         `json:"version"`
+	Type        api.SchemaType `json:"type"`
+	Definition  []byte         `json:"definition"`
+	MessageName string         `json:"message_name,omitempty"`
+}
+
+type subject struct {
+	Compatibility api.Compatibility `json:"compatibility"`
+	Versions      []storedSchema    `json:"versions"`
+}
+
+type validator interface {
+	// 値がスキーマに適合しない理由を返す。適合する場合は空
+	validate(value []byte) []string
+}
+
+// トピックごとにバージョン管理したスキーマを保持する
+type Registry struct {
+	mu         sync.RWMutex
+	dir        string
+	subjects   map[string]*subject
+	validators map[string]validator // トピックごとの最新のスキーマ
+}
+
+func NewRegistry(dir string) (*Registry, error) {
+	r := &Registry{
+		dir:        dir,
+		subjects:   map[string]*subject{},
+		validators: map[string]validator{},
+	}
+	b, err := os.ReadFile(filepath.Join(dir, registryFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &r.subjects); err != nil {
+		return nil, err
+	}
+	for topic, sub := range r.subjects {
+		if len(sub.Versions) == 0 {
+			continue
+		}
+		v, err := compile(sub.Versions[len(sub.Versions)-1])
+		if err != nil {
+			return nil, err
+		}
+		r.validators[topic] = v
+	}
+	return r, nil
+}
+
+// スキーマを新しいバージョンとして登録する。最新と同じ内容の場合は最新のバージョンを返す
+func (r *Registry) Register(schema *api.Schema) (uint32, error) {
+	if schema.GetTopic() == "" {
+		return 0, status.Error(codes.InvalidArgument, "topic is required")
+	}
+	stored := storedSchema{
+		Type:        schema.Type,
+		Definition:  schema.Definition,
+		MessageName: schema.MessageName,
+	}
+	v, err := compile(stored)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid schema: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sub := r.subject(schema.Topic)
+	if n := len(sub.Versions); n > 0 {
+		latest := sub.Versions[n-1]
+		if latest.Type == stored.Type && latest.MessageName == stored.MessageName &&
+			bytes.Equal(latest.Definition, stored.Definition) {
+			return latest.Version, nil
+		}
+		if reasons := checkCompatibility(sub.Compatibility, latest, stored); len(reasons) > 0 {
+			return 0, api.ErrIncompatibleSchema{Topic: schema.Topic, Reasons: reasons}
+		}
+		stored.Version = latest.Version + 1
+	} else {
+		stored.Version = 1
+	}
+
+	sub.Versions = append(sub.Versions, stored)
+	if err := r.save(); err != nil {
+		sub.Versions = sub.Versions[:len(sub.Versions)-1]
+		return 0, err
+	}
+	r.validators[schema.Topic] = v
+	return stored.Version, nil
+}
+
+// 指定したバージョンのスキーマを返す。version が 0 の場合は最新を返す
+func (r *Registry) Get(topic string, version uint32) (*api.Schema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sub, ok := r.subjects[topic]
+	if !ok || len(sub.Versions) == 0 {
+		return nil, api.ErrSchemaNotFound{Topic: topic, Version: version}
+	}
+	if version == 0 {
+		version = sub.Versions[len(sub.Versions)-1].Version
+	}
+	for _, s := range sub.Versions {
+		if s.Version == version {
+			return &api.Schema{
+				Topic:       topic,
+				Version:     s.Version,
+				Type:        s.Type,
+				Definition:  s.Definition,
+				MessageName: s.MessageName,
+			}, nil
+		}
+	}
+	return nil, api.ErrSchemaNotFound{Topic: topic, Version: version}
+}
+
+func (r *Registry) SetCompatibility(topic string, compatibility api.Compatibility) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sub := r.subject(topic)
+	before := sub.Compatibility
+	sub.Compatibility = compatibility
+	if err := r.save(); err != nil {
+		sub.Compatibility = before
+		return err
+	}
+	return nil
+}
+
+// 値をトピックの最新のスキーマで検証する
+func (r *Registry) Validate(topic string, value []byte) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	v, ok := r.validators[topic]
+	if !ok {
+		return api.ErrSchemaNotFound{Topic: topic}
+	}
+	if violations := v.validate(value); len(violations) > 0 {
+		sub := r.subjects[topic]
+		return api.ErrInvalidRecordValue{
+			Topic:      topic,
+			Version:    sub.Versions[len(sub.Versions)-1].Version,
+			Violations: violations,
+		}
+	}
+	return nil
+}
+
+func (r *Registry) subject(topic string) *subject {
+	sub, ok := r.subjects[topic]
+	if !ok {
+		sub = &subject{Compatibility: defaultCompatibility}
+		r.subjects[topic] = sub
+	}
+	return sub
+}
+
+// 一時ファイルに書いてからリネームすることで、書き込み途中のファイルを残さない
+func (r *Registry) save() error {
+	b, err := json.Marshal(r.subjects)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(r.dir, 0700); err != nil {
+		return err
+	}
+	tmp := filepath.Join(r.dir, registryFile+".tmp")
+	if err = os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(r.dir, registryFile))
+}
+
+func compile(s storedSchema) (validator, error) {
+	switch s.Type {
+	case api.SchemaType_SCHEMA_TYPE_PROTOBUF:
+		return compileProto(s.Definition, s.MessageName)
+	case api.SchemaType_SCHEMA_TYPE_JSON:
+		return compileJSON(s.Definition)
+	}
+	return nil, errors.New("unknown schema type")
+}
+
+func checkCompatibility(c api.Compatibility, old, new storedSchema) []string {
+	if c == api.Compatibility_COMPATIBILITY_NONE {
+		return nil
+	}
+	if old.Type != new.Type {
+		return []string{"schema type cannot be changed"}
+	}
+	var reasons []string
+	if c == api.Compatibility_COMPATIBILITY_BACKWARD || c == api.Compatibility_COMPATIBILITY_FULL {
+		reasons = append(reasons, canRead(new, old)...)
+	}
+	if c == api.Compatibility_COMPATIBILITY_FORWARD || c == api.Compatibility_COMPATIBILITY_FULL {
+		reasons = append(reasons, canRead(old, new)...)
+	}
+	return reasons
+}
+
+// reader のスキーマで writer のスキーマのデータを読めない理由を返す
+func canRead(reader, writer storedSchema) []string {
+	switch reader.Type {
+	case api.SchemaType_SCHEMA_TYPE_PROTOBUF:
+		r, _ := compileProto(reader.Definition, reader.MessageName)
+		w, _ := compileProto(writer.Definition, writer.MessageName)
+		return r.canRead(w)
+	case api.SchemaType_SCHEMA_TYPE_JSON:
+		r, _ := compileJSON(reader.Definition)
+		w, _ := compileJSON(writer.Definition)
+		return r.canRead(w)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package schema
+
+import (
+	"os"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestRegistry(t *testing.T) {
+	for senario, fn := range map[string]func(*testing.T, *Registry){
+		"register and get":                 testRegisterGet,
+		"validate protobuf value":          testValidateProto,
+		"validate json value":              testValidateJSON,
+		"incompatible protobuf is refused": testIncompatibleProto,
+		"incompatible json is refused":     testIncompatibleJSON,
+		"compatibility none allows change": testCompatibilityNone,
+	} {
+		t.Run(senario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "schema_test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			r, err := NewRegistry(dir)
+			require.NoError(t, err)
+			fn(t, r)
+		})
+	}
+}
+
+type testField struct {
+	name   string
+	number int32
+	typ    descriptorpb.FieldDescriptorProto_Type
+}
+
+// テスト用に1メッセージだけ持つ FileDescriptorSet を作る
+func protoDefinition(t *testing.T, fields ...testField) []byte {
+	t.Helper()
+	msg := &descriptorpb.DescriptorProto{Name: proto.String("User")}
+	for _, f := range fields {
+		msg.Field = append(msg.Field, &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(f.name),
+			Number:   proto.Int32(f.number),
+			Type:     f.typ.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String(f.name),
+		})
+	}
+	b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:        proto.String("user.proto"),
+			Package:     proto.String("test"),
+			Syntax:      proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{msg},
+		}},
+	})
+	require.NoError(t, err)
+	return b
+}
+
+func protoSchemaOf(t *testing.T, fields ...testField) *api.Schema {
+	return &api.Schema{
+		Topic:       "users",
+		Type:        api.SchemaType_SCHEMA_TYPE_PROTOBUF,
+		Definition:  protoDefinition(t, fields...),
+		MessageName: "test.User",
+	}
+}
+
+func jsonSchemaOf(definition string) *api.Schema {
+	return &api.Schema{
+		Topic:      "users",
+		Type:       api.SchemaType_SCHEMA_TYPE_JSON,
+		Definition: []byte(definition),
+	}
+}
+
+var (
+	nameField = testField{"name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING}
+	ageField  = testField{"age", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32}
+)
+
+func testRegisterGet(t *testing.T, r *Registry) {
+	_, err := r.Get("users", 0)
+	require.ErrorAs(t, err, &api.ErrSchemaNotFound{})
+
+	v1, err := r.Register(protoSchemaOf(t, nameField))
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), v1)
+	// 同じ内容の登録は新しいバージョンにしない
+	again, err := r.Register(protoSchemaOf(t, nameField))
+	require.NoError(t, err)
+	require.Equal(t, v1, again)
+	v2, err := r.Register(protoSchemaOf(t, nameField, ageField))
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), v2)
+
+	latest, err := r.Get("users", 0)
+	require.NoError(t, err)
+	require.Equal(t, v2, latest.Version)
+	first, err := r.Get("users", v1)
+	require.NoError(t, err)
+	require.Equal(t, protoDefinition(t, nameField), first.Definition)
+	_, err = r.Get("users", 3)
+	require.ErrorAs(t, err, &api.ErrSchemaNotFound{})
+
+	// 再起動後も残る
+	restored, err := NewRegistry(r.dir)
+	require.NoError(t, err)
+	got, err := restored.Get("users", 0)
+	require.NoError(t, err)
+	require.Equal(t, v2, got.Version)
+	require.NoError(t, restored.Validate("users", []byte{}))
+}
+
+func testValidateProto(t *testing.T, r *Registry) {
+	require.ErrorAs(t, r.Validate("users", nil), &api.ErrSchemaNotFound{})
+
+	schema := protoSchemaOf(t, nameField)
+	_, err := r.Register(schema)
+	require.NoError(t, err)
+
+	ps, err := compileProto(schema.Definition, schema.MessageName)
+	require.NoError(t, err)
+	msg := dynamicpb.NewMessage(ps.desc)
+	msg.Set(ps.desc.Fields().ByName("name"), protoreflect.ValueOfString("alice"))
+	valid, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	require.NoError(t, r.Validate("users", valid))
+
+	// 定義されていないフィールドは新しいスキーマで追加されたフィールドとして許容する
+	unknown := append(valid, 0x10, 0x01)
+	require.NoError(t, r.Validate("users", unknown))
+	require.ErrorAs(t, r.Validate("users", []byte{0xff}), &api.ErrInvalidRecordValue{})
+}
+
+func testValidateJSON(t *testing.T, r *Registry) {
+	_, err := r.Register(jsonSchemaOf(`{
+		"type": "object",
+		"properties": {"name": {"type": "string"}},
+		"required": ["name"]
+	}`))
+	require.NoError(t, err)
+
+	require.NoError(t, r.Validate("users", []byte(`{"name":"alice"}`)))
+	err = r.Validate("users", []byte(`{"age":1}`))
+	invalid := api.ErrInvalidRecordValue{}
+	require.ErrorAs(t, err, &invalid)
+	require.NotEmpty(t, invalid.Violations)
+	require.ErrorAs(t, r.Validate("users", []byte(`not json`)), &api.ErrInvalidRecordValue{})
+}
+
+func testIncompatibleProto(t *testing.T, r *Registry) {
+	_, err := r.Register(protoSchemaOf(t, nameField, ageField))
+	require.NoError(t, err)
+
+	changed := testField{"age", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING}
+	_, err = r.Register(protoSchemaOf(t, nameField, changed))
+	require.ErrorAs(t, err, &api.ErrIncompatibleSchema{})
+
+	// フィールドの削除は互換
+	_, err = r.Register(protoSchemaOf(t, nameField))
+	require.NoError(t, err)
+}
+
+func testIncompatibleJSON(t *testing.T, r *Registry) {
+	_, err := r.Register(jsonSchemaOf(`{"type":"object","properties":{"name":{"type":"string"}}}`))
+	require.NoError(t, err)
+
+	// 古いデータにない必須プロパティを追加すると後方互換でない
+	_, err = r.Register(jsonSchemaOf(`{
+		"type":"object",
+		"properties":{"name":{"type":"string"},"age":{"type":"integer"}},
+		"required":["age"]
+	}`))
+	require.ErrorAs(t, err, &api.ErrIncompatibleSchema{})
+
+	require.NoError(t, r.SetCompatibility("users", api.Compatibility_COMPATIBILITY_FULL))
+	// 任意のプロパティの追加は前方互換でもある
+	_, err = r.Register(jsonSchemaOf(`{
+		"type":"object",
+		"properties":{"name":{"type":"string"},"age":{"type":"integer"}}
+	}`))
+	require.NoError(t, err)
+	_, err = r.Register(jsonSchemaOf(`{"type":"object","properties":{"name":{"type":"integer"}}}`))
+	require.ErrorAs(t, err, &api.ErrIncompatibleSchema{})
+}
+
+func testCompatibilityNone(t *testing.T, r *Registry) {
+	_, err := r.Register(protoSchemaOf(t, nameField))
+	require.NoError(t, err)
+	_, err = r.Register(jsonSchemaOf(`{"type":"object"}`))
+	require.ErrorAs(t, err, &api.ErrIncompatibleSchema{})
+
+	require.NoError(t, r.SetCompatibility("users", api.Compatibility_COMPATIBILITY_NONE))
+	version, err := r.Register(jsonSchemaOf(`{"type":"object"}`))
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), version)
+
+	_, err = r.Register(&api.Schema{Topic: "users", Type: api.SchemaType_SCHEMA_TYPE_JSON, Definition: []byte("{")})
+	require.Error(t, err)
+}
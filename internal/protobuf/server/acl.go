@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const aclServicePrefix = "/log.v1.Acl/"
+
+// 認可のポリシーの永続化先。変更は Authorizer にも反映される
+type PolicyStore interface {
+	Policies() ([]auth.Rule, error)
+	AddPolicy(rule auth.Rule) error
+	RemovePolicy(rule auth.Rule) error
+}
+
+type aclServer struct {
+	api.UnimplementedAclServer
+	*Config
+}
+
+var _ api.AclServer = (*aclServer)(nil)
+
+// 操作ごとに "acl:AddPolicy" のようなリソースとして認可する
+func (s *aclServer) authorize(ctx context.Context) error {
+	method, _ := grpc.Method(ctx)
+	return s.authorizeRequest(
+		ctx, aclObject(strings.TrimPrefix(method, aclServicePrefix)), aclAction,
+	)
+}
+
+func (s *aclServer) ListPolicies(ctx context.Context, req *api.ListPoliciesRequest) (*api.ListPoliciesResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	rules, err := s.Policies.Policies()
+	if err != nil {
+		return nil, err
+	}
+	res := &api.ListPoliciesResponse{}
+	for _, rule := range rules {
+		res.Rules = append(res.Rules, &api.PolicyRule{Type: rule.Type, Values: rule.Values})
+	}
+	return res, nil
+}
+
+func (s *aclServer) AddPolicy(ctx context.Context, req *api.AddPolicyRequest) (*api.AddPolicyResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	rule, err := toRule(req.Rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Policies.AddPolicy(rule); err != nil {
+		return nil, err
+	}
+	return &api.AddPolicyResponse{}, nil
+}
+
+func (s *aclServer) RemovePolicy(ctx context.Context, req *api.RemovePolicyRequest) (*api.RemovePolicyResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	rule, err := toRule(req.Rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Policies.RemovePolicy(rule); err != nil {
+		return nil, err
+	}
+	return &api.RemovePolicyResponse{}, nil
+}
+
+func toRule(rule *api.PolicyRule) (auth.Rule, error) {
+	if rule == nil {
+		return auth.Rule{}, status.Error(codes.InvalidArgument, "rule is required")
+	}
+	return auth.Rule{Type: rule.Type, Values: rule.Values}, nil
+}
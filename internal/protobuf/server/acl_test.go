@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/auth"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/config"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAcl(t *testing.T) {
+	for senario, fn := range map[string]func(*testing.T, api.AclClient, *auth.Authorizer){
+		"add and remove": testAclAddRemove,
+		"invalid rule":   testAclInvalidRule,
+	} {
+		t.Run(senario, func(t *testing.T) {
+			client, policies, _ := setupAcl(t, true)
+			fn(t, client, policies)
+		})
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		client, _, auditor := setupAcl(t, false)
+		_, err := client.ListPolicies(context.Background(), &api.ListPoliciesRequest{})
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+		entry := auditor.last()
+		require.Equal(t, "/log.v1.Acl/ListPolicies", entry.Method)
+		require.Equal(t, codes.PermissionDenied.String(), entry.Code)
+	})
+}
+
+func setupAcl(t *testing.T, allowed bool) (api.AclClient, *auth.Authorizer, *recordingAuditor) {
+	t.Helper()
+	dir := t.TempDir()
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() { clog.Close() })
+
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte("p, root, *, produce\n"), 0600))
+	policies, err := auth.New(config.ACLModelFile, policy)
+	require.NoError(t, err)
+
+	auditor := &recordingAuditor{}
+	conn := serveInsecure(t, &Config{
+		CommitLog:      clog,
+		AllowAnonymous: true,
+		Authorizer:     actionAuthorizer{allowed: map[string]bool{aclAction: allowed}},
+		Policies:       policies,
+		Auditor:        auditor,
+	})
+	return api.NewAclClient(conn), policies, auditor
+}
+
+func testAclAddRemove(t *testing.T, client api.AclClient, policies *auth.Authorizer) {
+	ctx := context.Background()
+	rule := &api.PolicyRule{Type: "p", Values: []string{"alice", "topic:orders", "consume"}}
+	require.Error(t, policies.Authorize("alice", "topic:orders", "consume"))
+
+	_, err := client.AddPolicy(ctx, &api.AddPolicyRequest{Rule: rule})
+	require.NoError(t, err)
+	require.NoError(t, policies.Authorize("alice", "topic:orders", "consume"))
+	res, err := client.ListPolicies(ctx, &api.ListPoliciesRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.Rules, 2)
+	require.Equal(t, rule.Values, res.Rules[1].Values)
+
+	_, err = client.RemovePolicy(ctx, &api.RemovePolicyRequest{Rule: rule})
+	require.NoError(t, err)
+	require.Error(t, policies.Authorize("alice", "topic:orders", "consume"))
+	_, err = client.RemovePolicy(ctx, &api.RemovePolicyRequest{Rule: rule})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testAclInvalidRule(t *testing.T, client api.AclClient, policies *auth.Authorizer) {
+	ctx := context.Background()
+	_, err := client.AddPolicy(ctx, &api.AddPolicyRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.AddPolicy(ctx, &api.AddPolicyRequest{
+		Rule: &api.PolicyRule{Type: "p", Values: []string{"alice", "topic:orders"}},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const adminServicePrefix = "/log.v1.Admin/"
+
+type AdminLog interface {
+	Stats() log.Stats
+	Flush() error
+	Truncate(lowest uint64) error
+	TruncateAfter(offset uint64) error
+	Config() log.Config
+	UpdateConfig(conf log.Config) error
+}
+
+type adminServer struct {
+	api.UnimplementedAdminServer
+	*Config
+}
+
+var _ api.AdminServer = (*adminServer)(nil)
+
+// 操作ごとに "admin:Flush" のようなリソースとして認可する
+func (s *adminServer) authorize(ctx context.Context) error {
+	method, _ := grpc.Method(ctx)
+	return s.authorizeRequest(
+		ctx, adminObject(strings.TrimPrefix(method, adminServicePrefix)), adminAction,
+	)
+}
+
+func (s *adminServer) GetStats(ctx context.Context, req *api.GetStatsRequest) (*api.GetStatsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := s.Admin.Stats()
+	low, high := watermarks(stats)
+	return &api.GetStatsResponse{
+		LowWatermark:     low,
+		HighWatermark:    high,
+		SegmentCount:     uint32(len(stats.Segments)),
+		ActiveIndexUsage: stats.ActiveIndexUsage,
+		TotalStoreBytes:  stats.TotalStoreBytes,
+		TotalIndexBytes:  stats.TotalIndexBytes,
+		TotalDiskBytes:   stats.TotalDiskBytes,
+		TotalBlobBytes:   stats.TotalBlobBytes,
+	}, nil
+}
+
+func (s *adminServer) ListSegments(ctx context.Context, req *api.ListSegmentsRequest) (*api.ListSegmentsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := s.Admin.Stats()
+	res := &api.ListSegmentsResponse{}
+	for _, ss := range stats.Segments {
+		res.Segments = append(res.Segments, &api.Segment{
+			BaseOffset:     ss.BaseOffset,
+			NextOffset:     ss.NextOffset,
+			StoreBytes:     ss.StoreBytes,
+			IndexBytes:     ss.IndexBytes,
+			IndexFileBytes: ss.IndexFileBytes,
+			Active:         ss.Active,
+		})
+	}
+	return res, nil
+}
+
+func (s *adminServer) Flush(ctx context.Context, req *api.FlushRequest) (*api.FlushResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.Admin.Flush(); err != nil {
+		return nil, err
+	}
+	return &api.FlushResponse{}, nil
+}
+
+func (s *adminServer) TruncateBefore(ctx context.Context, req *api.TruncateBeforeRequest) (*api.TruncateResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	// アクティブセグメントまで削除しないよう、次に追記されるオフセットより前に限る
+	if _, high := watermarks(s.Admin.Stats()); req.Offset >= high {
+		return nil, status.Errorf(codes.InvalidArgument, "offset %d must be less than high watermark %d", req.Offset, high)
+	}
+	if req.Offset > 0 {
+		if err := s.Admin.Truncate(req.Offset - 1); err != nil {
+			return nil, err
+		}
+	}
+	return s.truncateResponse(), nil
+}
+
+func (s *adminServer) TruncateAfter(ctx context.Context, req *api.TruncateAfterRequest) (*api.TruncateResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.Admin.TruncateAfter(req.Offset); err != nil {
+		return nil, err
+	}
+	return s.truncateResponse(), nil
+}
+
+func (s *adminServer) truncateResponse() *api.TruncateResponse {
+	low, high := watermarks(s.Admin.Stats())
+	return &api.TruncateResponse{LowWatermark: low, HighWatermark: high}
+}
+
+func (s *adminServer) UpdateConfig(ctx context.Context, req *api.UpdateConfigRequest) (*api.UpdateConfigResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	conf := s.Admin.Config()
+	if c := req.Config; c != nil {
+		if c.MaxStoreBytes != nil {
+			conf.Segment.MaxStoreBytes = *c.MaxStoreBytes
+		}
+		if c.MaxIndexBytes != nil {
+			conf.Segment.MaxIndexBytes = *c.MaxIndexBytes
+		}
+		// gRPC のメッセージサイズの上限は起動時に決まるので、レコードの最大サイズだけ変えられない
+		if c.MaxRecordBytes != nil && *c.MaxRecordBytes != conf.Record.MaxBytes {
+			return nil, status.Error(codes.InvalidArgument, "max_record_bytes cannot be changed at runtime")
+		}
+		if c.OffloadThreshold != nil {
+			conf.Record.OffloadThreshold = *c.OffloadThreshold
+		}
+		if c.MinFreeBytes != nil {
+			conf.Storage.MinFreeBytes = *c.MinFreeBytes
+		}
+		if c.MaxLogBytes != nil {
+			conf.Storage.MaxLogBytes = *c.MaxLogBytes
+		}
+		if c.MaxOpenSegments != nil {
+			conf.SegmentCache.MaxOpenSegments = int(*c.MaxOpenSegments)
+		}
+	}
+	if err := s.Admin.UpdateConfig(conf); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &api.UpdateConfigResponse{Config: toLogConfig(s.Admin.Config())}, nil
+}
+
+func toLogConfig(conf log.Config) *api.LogConfig {
+	maxOpenSegments := uint32(conf.SegmentCache.MaxOpenSegments)
+	return &api.LogConfig{
+		MaxStoreBytes:    &conf.Segment.MaxStoreBytes,
+		MaxIndexBytes:    &conf.Segment.MaxIndexBytes,
+		MaxRecordBytes:   &conf.Record.MaxBytes,
+		OffloadThreshold: &conf.Record.OffloadThreshold,
+		MinFreeBytes:     &conf.Storage.MinFreeBytes,
+		MaxLogBytes:      &conf.Storage.MaxLogBytes,
+		MaxOpenSegments:  &maxOpenSegments,
+	}
+}
+
+// 残っている最も古いオフセットと次に追記されるオフセット
+func watermarks(stats log.Stats) (low, high uint64) {
+	if len(stats.Segments) == 0 {
+		return 0, 0
+	}
+	return stats.LowestOffset, stats.Segments[len(stats.Segments)-1].NextOffset
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type recordingAuditor struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+func (a *recordingAuditor) Audit(entry AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, entry)
+}
+
+func (a *recordingAuditor) last() AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.entries[len(a.entries)-1]
+}
+
+func TestAdmin(t *testing.T) {
+	for senario, fn := range map[string]func(*testing.T, api.AdminClient, *log.Log, *recordingAuditor){
+		"stats and segments": testAdminStats,
+		"truncate":           testAdminTruncate,
+		"update config":      testAdminUpdateConfig,
+	} {
+		t.Run(senario, func(t *testing.T) {
+			clog, client, auditor := setupAdmin(t, true)
+			fn(t, client, clog, auditor)
+		})
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		_, client, auditor := setupAdmin(t, false)
+		_, err := client.Flush(context.Background(), &api.FlushRequest{})
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+		entry := auditor.last()
+		require.Equal(t, "/log.v1.Admin/Flush", entry.Method)
+		require.Equal(t, codes.PermissionDenied.String(), entry.Code)
+	})
+}
+
+func setupAdmin(t *testing.T, allowed bool) (*log.Log, api.AdminClient, *recordingAuditor) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "admin-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	conf := log.Config{}
+	conf.Segment.MaxStoreBytes = 32
+	clog, err := log.NewLog(dir, conf)
+	require.NoError(t, err)
+	t.Cleanup(func() { clog.Close() })
+	for i := 0; i < 10; i++ {
+		_, err := clog.Append(&api.Record{Value: []byte(fmt.Sprintf("%d", i))})
+		require.NoError(t, err)
+	}
+
+	auditor := &recordingAuditor{}
+	conn := serveInsecure(t, &Config{
+		CommitLog:      clog,
+		AllowAnonymous: true,
+		Authorizer:     actionAuthorizer{allowed: map[string]bool{adminAction: allowed}},
+		Admin:          clog,
+		Auditor:        auditor,
+	})
+	return clog, api.NewAdminClient(conn), auditor
+}
+
+func testAdminStats(t *testing.T, client api.AdminClient, clog *log.Log, auditor *recordingAuditor) {
+	ctx := context.Background()
+	stats, err := client.GetStats(ctx, &api.GetStatsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), stats.LowWatermark)
+	require.Equal(t, uint64(10), stats.HighWatermark)
+	require.Greater(t, stats.SegmentCount, uint32(1))
+
+	segments, err := client.ListSegments(ctx, &api.ListSegmentsRequest{})
+	require.NoError(t, err)
+	require.Len(t, segments.Segments, int(stats.SegmentCount))
+	require.True(t, segments.Segments[len(segments.Segments)-1].Active)
+
+	_, err = client.Flush(ctx, &api.FlushRequest{})
+	require.NoError(t, err)
+	entry := auditor.last()
+	require.Equal(t, "/log.v1.Admin/Flush", entry.Method)
+	require.Equal(t, codes.OK.String(), entry.Code)
+}
+
+func testAdminTruncate(t *testing.T, client api.AdminClient, clog *log.Log, auditor *recordingAuditor) {
+	ctx := context.Background()
+	res, err := client.TruncateAfter(ctx, &api.TruncateAfterRequest{Offset: 7})
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), res.HighWatermark)
+	require.Contains(t, string(auditor.last().Request), `"offset":"7"`)
+
+	res, err = client.TruncateBefore(ctx, &api.TruncateBeforeRequest{Offset: 5})
+	require.NoError(t, err)
+	require.Greater(t, res.LowWatermark, uint64(0))
+	require.LessOrEqual(t, res.LowWatermark, uint64(5))
+	_, err = clog.Read(5)
+	require.NoError(t, err)
+
+	_, err = client.TruncateBefore(ctx, &api.TruncateBeforeRequest{Offset: 8})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testAdminUpdateConfig(t *testing.T, client api.AdminClient, clog *log.Log, auditor *recordingAuditor) {
+	ctx := context.Background()
+	res, err := client.UpdateConfig(ctx, &api.UpdateConfigRequest{
+		Config: &api.LogConfig{OffloadThreshold: proto.Uint64(100)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), res.Config.GetOffloadThreshold())
+	require.Equal(t, uint64(32), res.Config.GetMaxStoreBytes())
+	require.Equal(t, uint64(100), clog.Config().Record.OffloadThreshold)
+
+	// 取得した設定をそのまま送り返すのは変更に当たらない
+	_, err = client.UpdateConfig(ctx, &api.UpdateConfigRequest{Config: res.Config})
+	require.NoError(t, err)
+
+	// gRPC のメッセージサイズの上限と食い違うので、レコードの最大サイズは変えられない
+	_, err = client.UpdateConfig(ctx, &api.UpdateConfigRequest{
+		Config: &api.LogConfig{MaxRecordBytes: proto.Uint64(100)},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Equal(t, res.Config.GetMaxRecordBytes(), clog.Config().Record.MaxBytes)
+
+	_, err = client.UpdateConfig(ctx, &api.UpdateConfigRequest{
+		Config: &api.LogConfig{MaxStoreBytes: proto.Uint64(0)},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Equal(t, uint64(32), clog.Config().Segment.MaxStoreBytes)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// 管理操作の記録先
+type Auditor interface {
+	Audit(entry AuditEntry)
+}
+
+type AuditEntry struct {
+	Time    time.Time       `json:"time"`
+	Subject string          `json:"subject"`
+	Method  string          `json:"method"`
+	Request json.RawMessage `json:"request,omitempty"`
+	Code    string          `json:"code"`
+	Error   string          `json:"error,omitempty"`
+}
+
+func newAuditEntry(subject, method string, req interface{}, err error) AuditEntry {
+	entry := AuditEntry{
+		Time:    time.Now(),
+		Subject: subject,
+		Method:  method,
+		Code:    status.Code(err).String(),
+	}
+	if m, ok := req.(proto.Message); ok {
+		if b, err := protojson.Marshal(m); err == nil {
+			entry.Request = b
+		}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// 1行1件の JSON で書き込む
+type jsonAuditor struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONAuditor(w io.Writer) Auditor {
+	return &jsonAuditor{w: w}
+}
+
+func (a *jsonAuditor) Audit(entry AuditEntry) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.w.Write(append(b, '\n'))
+}
+
+var defaultAuditor = NewJSONAuditor(os.Stderr)
+
+func (c *Config) auditor() Auditor {
+	if c.Auditor == nil {
+		return defaultAuditor
+	}
+	return c.Auditor
+}
+
+// Admin・Acl サービスの呼び出しを、認可に失敗したものも含めて監査ログに記録する
+func (c *Config) audit(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if !strings.HasPrefix(info.FullMethod, adminServicePrefix) &&
+		!strings.HasPrefix(info.FullMethod, aclServicePrefix) {
+		return handler(ctx, req)
+	}
+	res, err := handler(ctx, req)
+	c.auditor().Audit(newAuditEntry(subject(ctx), info.FullMethod, req, err))
+	return res, err
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// プリンシパルの属性に記録する認証方式
+	authMethodAttribute = "auth"
+	authMethodMTLS      = "mtls"
+	authMethodSPIFFE    = "spiffe"
+	authMethodToken     = "token"
+)
+
+// RPCの呼び出し元を認証する。認証情報がない場合は ok が false、認証情報が不正な場合はエラーを返す
+type Authenticator interface {
+	Authenticate(ctx context.Context) (principal auth.Principal, ok bool, err error)
+}
+
+type principalContextKey struct{}
+
+// Config.Authenticators を先頭から順に試し、最初に認証できたプリンシパルをRPCのコンテキストに書き込むinterceptor
+func (c *Config) authenticate(ctx context.Context) (context.Context, error) {
+	// ロードバランサーなどが認証情報なしで確認できるよう、ヘルスチェックは認証しない
+	if method, ok := grpc.Method(ctx); ok && strings.HasPrefix(method, healthServicePrefix) {
+		return ctx, nil
+	}
+	authenticators := c.Authenticators
+	if authenticators == nil {
+		authenticators = []Authenticator{CommonNameAuthenticator{}}
+	}
+	for _, a := range authenticators {
+		p, ok, err := a.Authenticate(ctx)
+		if err != nil {
+			return ctx, status.Errorf(codes.Unauthenticated, "authentication failed: %v", err)
+		}
+		if ok {
+			return context.WithValue(ctx, principalContextKey{}, p), nil
+		}
+	}
+	if c.AllowAnonymous {
+		return context.WithValue(ctx, principalContextKey{}, auth.Principal{}), nil
+	}
+	return ctx, status.Error(codes.Unauthenticated, "no credentials")
+}
+
+func principal(ctx context.Context) auth.Principal {
+	p, _ := ctx.Value(principalContextKey{}).(auth.Principal)
+	return p
+}
+
+// 呼び出し元のプリンシパルの名前を返す
+func subject(ctx context.Context) string {
+	return principal(ctx).Name
+}
+
+// 検証済みのクライアント証明書。TLS でない接続や、証明書を提示しなかった接続は ok が false
+func clientCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx) // 接続元の情報
+	if !ok || p.AuthInfo == nil {
+		return nil, false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return tlsInfo.State.VerifiedChains[0][0], true
+}
+
+// クライアント証明書のサブジェクトの CommonName を名前とする
+type CommonNameAuthenticator struct{}
+
+func (CommonNameAuthenticator) Authenticate(ctx context.Context) (auth.Principal, bool, error) {
+	cert, ok := clientCertificate(ctx)
+	if !ok || cert.Subject.CommonName == "" {
+		return auth.Principal{}, false, nil
+	}
+	return auth.Principal{
+		Name:       cert.Subject.CommonName,
+		Attributes: map[string]string{authMethodAttribute: authMethodMTLS},
+	}, true, nil
+}
+
+// クライアント証明書の URI SAN の SPIFFE ID を名前とする。SPIFFE ID は1つでなければならない
+type SPIFFEAuthenticator struct {
+	// 受け入れるトラストドメイン (空: すべて)
+	TrustDomains []string
+}
+
+func (a SPIFFEAuthenticator) Authenticate(ctx context.Context) (auth.Principal, bool, error) {
+	cert, ok := clientCertificate(ctx)
+	if !ok {
+		return auth.Principal{}, false, nil
+	}
+	var ids []*url.URL
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			ids = append(ids, uri)
+		}
+	}
+	switch {
+	case len(ids) == 0:
+		return auth.Principal{}, false, nil
+	case len(ids) > 1:
+		return auth.Principal{}, false, errors.New("certificate has multiple SPIFFE IDs")
+	}
+	id := ids[0]
+	if id.Host == "" || id.User != nil || id.RawQuery != "" || id.Fragment != "" {
+		return auth.Principal{}, false, fmt.Errorf("invalid SPIFFE ID: %s", id)
+	}
+	if !a.trusts(id.Host) {
+		return auth.Principal{}, false, fmt.Errorf("untrusted trust domain: %s", id.Host)
+	}
+	return auth.Principal{
+		Name: id.String(),
+		Attributes: map[string]string{
+			authMethodAttribute: authMethodSPIFFE,
+			"trust_domain":      id.Host,
+		},
+	}, true, nil
+}
+
+func (a SPIFFEAuthenticator) trusts(domain string) bool {
+	if len(a.TrustDomains) == 0 {
+		return true
+	}
+	for _, d := range a.TrustDomains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
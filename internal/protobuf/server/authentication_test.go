@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/auth"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var testTokenKey = []byte("0123456789abcdef0123456789abcdef")
+
+func signToken(t *testing.T, alg, kid string, key []byte, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signing))
+	return signing + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func tokenContext(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+// クライアント証明書を提示した TLS 接続のコンテキスト。cert が nil の場合は証明書なし
+func tlsContext(t *testing.T, cert *x509.Certificate) context.Context {
+	t.Helper()
+	state := tls.ConnectionState{}
+	if cert != nil {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{},
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
+
+func newCertificate(t *testing.T, commonName string, uris ...string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	for _, u := range uris {
+		parsed, err := url.Parse(u)
+		require.NoError(t, err)
+		tmpl.URIs = append(tmpl.URIs, parsed)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tokens := TokenAuthenticator{
+		Keys:     KeySet{"k1": testTokenKey},
+		Claims:   []string{"role"},
+		Issuer:   "issuer",
+		Audience: "proglog",
+		Now:      func() time.Time { return now },
+	}
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "alice", "iss": "issuer", "aud": []string{"proglog"},
+			"exp": now.Add(time.Minute).Unix(), "role": "auditor",
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	chain := []Authenticator{tokens, SPIFFEAuthenticator{TrustDomains: []string{"example.org"}}, CommonNameAuthenticator{}}
+
+	for senario, tc := range map[string]struct {
+		ctx       context.Context
+		anonymous bool
+		want      auth.Principal
+		code      codes.Code
+	}{
+		"common name": {
+			ctx:  tlsContext(t, newCertificate(t, "root")),
+			want: auth.Principal{Name: "root", Attributes: map[string]string{"auth": "mtls"}},
+		},
+		"spiffe id is preferred to common name": {
+			ctx: tlsContext(t, newCertificate(t, "root", "spiffe://example.org/ns/default/sa/orders")),
+			want: auth.Principal{
+				Name:       "spiffe://example.org/ns/default/sa/orders",
+				Attributes: map[string]string{"auth": "spiffe", "trust_domain": "example.org"},
+			},
+		},
+		"untrusted spiffe trust domain": {
+			ctx:  tlsContext(t, newCertificate(t, "root", "spiffe://evil.example/sa/orders")),
+			code: codes.Unauthenticated,
+		},
+		"multiple spiffe ids": {
+			ctx:  tlsContext(t, newCertificate(t, "", "spiffe://example.org/a", "spiffe://example.org/b")),
+			code: codes.Unauthenticated,
+		},
+		"tls without client certificate": {
+			ctx:  tlsContext(t, nil),
+			code: codes.Unauthenticated,
+		},
+		"no peer": {
+			ctx:  context.Background(),
+			code: codes.Unauthenticated,
+		},
+		"anonymous": {
+			ctx:       context.Background(),
+			anonymous: true,
+			want:      auth.Principal{},
+		},
+		"bearer token": {
+			ctx: tokenContext(signToken(t, "HS256", "k1", testTokenKey, claims(nil))),
+			// Claims に挙げていない iss は属性にしない
+			want: auth.Principal{Name: "alice", Attributes: map[string]string{
+				"auth": "token", "role": "auditor",
+			}},
+		},
+		"invalid signature": {
+			ctx:  tokenContext(signToken(t, "HS256", "k1", []byte("other"), claims(nil))),
+			code: codes.Unauthenticated,
+		},
+		"unknown key": {
+			ctx:  tokenContext(signToken(t, "HS256", "k2", testTokenKey, claims(nil))),
+			code: codes.Unauthenticated,
+		},
+		"unsigned token": {
+			ctx:  tokenContext(signToken(t, "none", "k1", testTokenKey, claims(nil))),
+			code: codes.Unauthenticated,
+		},
+		"expired token": {
+			ctx:  tokenContext(signToken(t, "HS256", "k1", testTokenKey, claims(map[string]interface{}{"exp": now.Unix()}))),
+			code: codes.Unauthenticated,
+		},
+		"wrong audience": {
+			ctx:  tokenContext(signToken(t, "HS256", "k1", testTokenKey, claims(map[string]interface{}{"aud": "other"}))),
+			code: codes.Unauthenticated,
+		},
+		"not a bearer token": {
+			ctx:  metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Basic YWxpY2U6")),
+			code: codes.Unauthenticated,
+		},
+	} {
+		t.Run(senario, func(t *testing.T) {
+			c := &Config{Authenticators: chain, AllowAnonymous: tc.anonymous}
+			ctx, err := c.authenticate(tc.ctx)
+			require.Equal(t, tc.code, status.Code(err))
+			if tc.code != codes.OK {
+				return
+			}
+			require.Equal(t, tc.want, principal(ctx))
+		})
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(name, []byte(`{"keys": [
+		{"kty": "oct", "kid": "k1", "k": "`+base64.RawURLEncoding.EncodeToString(testTokenKey)+`"},
+		{"kty": "RSA", "kid": "k2", "n": "AQAB"}
+	]}`), 0600))
+	keys, err := LoadKeySet(name)
+	require.NoError(t, err)
+	require.Equal(t, KeySet{"k1": testTokenKey}, keys)
+}
+
+type principalAuthorizer struct {
+	mu         sync.Mutex
+	principals []auth.Principal
+}
+
+func (a *principalAuthorizer) Authorize(subject, object, action string) error {
+	return status.Error(codes.Internal, "AuthorizePrincipal should be used")
+}
+
+func (a *principalAuthorizer) AuthorizePrincipal(principal auth.Principal, object, action string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.principals = append(a.principals, principal)
+	return nil
+}
+
+func TestBearerToken(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	authorizer := &principalAuthorizer{}
+	conn := serveInsecure(t, &Config{
+		CommitLog:      clog,
+		Authorizer:     authorizer,
+		Authenticators: []Authenticator{TokenAuthenticator{Keys: KeySet{"k1": testTokenKey}, Claims: []string{"role"}}},
+	})
+	client := api.NewLogClient(conn)
+	req := &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}}
+
+	_, err = client.Produce(context.Background(), req)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	token := signToken(t, "HS256", "k1", testTokenKey, map[string]interface{}{
+		"sub": "alice", "exp": time.Now().Add(time.Minute).Unix(), "role": "producer",
+	})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	_, err = client.Produce(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, []auth.Principal{{
+		Name:       "alice",
+		Attributes: map[string]string{"auth": "token", "role": "producer"},
+	}}, authorizer.principals)
+}
@@ -3,38 +3,44 @@ package server
 import (
 	"context"
 
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/peer"
-	"google.golang.org/grpc/status"
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/auth"
 )
 
 type Authorizer interface {
 	Authorize(subject, object, action string) error
 }
 
-type subjectContextKey struct{}
+// 名前だけでなく認証で得た属性も使って認可する Authorizer
+type PrincipalAuthorizer interface {
+	AuthorizePrincipal(principal auth.Principal, object, action string) error
+}
 
-// クライアントの証明書からサブジェクトを読み取ってRPCのコンテキストに書き込むinterceptor
-func authenticate(ctx context.Context) (context.Context, error) {
-	peer, ok := peer.FromContext(ctx) // 接続元の情報
-	if !ok {
-		return ctx, status.New(
-			codes.Unknown,
-			"could not find peer info",
-		).Err()
+// 呼び出し元のプリンシパルで認可する
+func (c *Config) authorizeRequest(ctx context.Context, object, action string) error {
+	if a, ok := c.Authorizer.(PrincipalAuthorizer); ok {
+		return a.AuthorizePrincipal(principal(ctx), object, action)
 	}
+	return c.Authorizer.Authorize(subject(ctx), object, action)
+}
 
-	if peer.AuthInfo == nil {
-		return context.WithValue(ctx, subjectContextKey{}, ""), nil
+// リクエストのトピックを、このサーバのログが保持するトピックに解決する。省略した場合はこのトピックとする。
+// ログは1つしかないので、別のトピックを指定したリクエストは読み書きさせずに NotFound にする
+func (c *Config) resolveTopic(topic string) (string, error) {
+	if topic != "" && topic != c.Topic {
+		return "", api.ErrTopicNotFound{Topic: topic}
 	}
-
-	tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
-	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
-	return context.WithValue(ctx, subjectContextKey{}, subject), nil
+	return c.Topic, nil
 }
 
-// クライアントの証明書のサブジェクトを返す
-func subject(ctx context.Context) string {
-	return ctx.Value(subjectContextKey{}).(string)
+// リクエストのトピックを解決し、解決したトピックで認可する
+func (c *Config) authorizeTopic(ctx context.Context, topic, action string) (string, error) {
+	topic, err := c.resolveTopic(topic)
+	if err != nil {
+		return "", err
+	}
+	if err := c.authorizeRequest(ctx, topicObject(topic), action); err != nil {
+		return "", err
+	}
+	return topic, nil
 }
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func (s *grpcServer) read(offset uint64, isolation api.IsolationLevel) (*api.Record, error) {
+	if isolation == api.IsolationLevel_READ_COMMITTED {
+		return s.CommitLog.ReadCommitted(offset)
+	}
+	return s.CommitLog.Read(offset)
+}
+
+// 件数・バイト数の上限まで読む。上限に達するか max_wait を過ぎるまで追記を待つ。
+// waitForRecord の場合、max_wait が 0 でも少なくとも1件読めるまで待つ
+func (s *grpcServer) fetch(ctx context.Context, req *api.ConsumeRequest, waitForRecord bool) (*api.ConsumeResponse, error) {
+	maxRecords := int(req.MaxRecords)
+	if maxRecords == 0 && req.MaxBytes == 0 {
+		maxRecords = 1
+	}
+	var deadline <-chan time.Time
+	if req.MaxWaitMs > 0 {
+		timer := time.NewTimer(time.Duration(req.MaxWaitMs) * time.Millisecond)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	res := &api.ConsumeResponse{NextOffset: req.Offset}
+	size := &fetchSize{}
+	for {
+		// 読み出しより前に取得しておき、読み終えてから待つまでの追記を取りこぼさない
+		changed := s.CommitLog.Changed()
+		full, err := s.fill(res, req, maxRecords, size)
+		if err != nil {
+			return nil, err
+		}
+		if full {
+			return res, nil
+		}
+		if deadline == nil && !(waitForRecord && len(res.Records) == 0) {
+			return res, nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return res, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// 応答に詰めたレコードのサイズ
+type fetchSize struct {
+	// レコードのサイズの合計。max_bytes と比べる
+	records int
+	// エンコードした records フィールドのサイズ。gRPC のメッセージサイズの上限と比べる
+	message int
+}
+
+// 読める分だけ res に加え、上限に達したかを返す
+func (s *grpcServer) fill(res *api.ConsumeResponse, req *api.ConsumeRequest, maxRecords int, size *fetchSize) (bool, error) {
+	maxMessage := s.maxMessageBytes()
+	for {
+		if maxRecords > 0 && len(res.Records) >= maxRecords {
+			return true, nil
+		}
+		record, err := s.read(res.NextOffset, req.IsolationLevel)
+		switch err.(type) {
+		case nil:
+		case api.ErrRecordFiltered, api.ErrRecordExpired:
+			res.NextOffset++
+			continue
+		case api.ErrOffsetOutOfRange:
+			return false, nil
+		default:
+			return false, err
+		}
+		recordSize := proto.Size(record)
+		if req.MaxBytes > 0 && len(res.Records) > 0 && size.records+recordSize > int(req.MaxBytes) {
+			return true, nil
+		}
+		messageSize := protowire.SizeTag(2) + protowire.SizeBytes(recordSize)
+		if len(res.Records) > 0 && size.message+messageSize > maxMessage {
+			return true, nil
+		}
+		res.Records = append(res.Records, record)
+		size.records += recordSize
+		size.message += messageSize
+		res.NextOffset++
+	}
+}
+
+// 応答のメッセージの最大サイズ。MaxRecordBytes を超えないレコードなら1件は必ず収まる
+func (s *grpcServer) maxMessageBytes() int {
+	if s.MaxRecordBytes == 0 {
+		return defaultMaxMessageBytes
+	}
+	return s.MaxRecordBytes + messageOverheadBytes
+}
+
+// 応答のメッセージに収まる先頭からの件数を返す。最初の1件は必ず含める
+func (s *grpcServer) fitMessage(records []*api.Record) int {
+	size := 0
+	for i, record := range records {
+		size += protowire.SizeTag(1) + protowire.SizeBytes(proto.Size(record))
+		if i > 0 && size > s.maxMessageBytes() {
+			return i
+		}
+	}
+	return len(records)
+}
+
+// max_records・max_bytes を指定しないクライアントには、1件を record だけで返す
+func legacyResponse(req *api.ConsumeRequest, res *api.ConsumeResponse) *api.ConsumeResponse {
+	if req.MaxRecords > 0 || req.MaxBytes > 0 || len(res.Records) == 0 {
+		return res
+	}
+	return &api.ConsumeResponse{Record: res.Records[0], NextOffset: res.NextOffset}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const defaultHealthCheckInterval = 5 * time.Second
+
+// 認証せずに呼び出せるヘルスチェックのメソッドの接頭辞
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// ヘルスチェックの状態を持つ gRPC サーバ。停止時は先に NOT_SERVING にする
+type Server struct {
+	*grpc.Server
+	health *health.Server
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NOT_SERVING にしてから処理中のリクエストの完了を待って停止する
+func (s *Server) GracefulStop() {
+	s.shutdown()
+	s.Server.GracefulStop()
+}
+
+func (s *Server) Stop() {
+	s.shutdown()
+	s.Server.Stop()
+}
+
+func (s *Server) shutdown() {
+	s.once.Do(func() {
+		close(s.stop)
+		// 以降の状態の更新は無視される
+		s.health.Shutdown()
+	})
+}
+
+// サービス全体 ("") と Log サービスの状態を返す
+func (s *Server) Check(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	res, err := s.health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, err
+	}
+	return res.Status, nil
+}
+
+func newHealthServer(gsrv *grpc.Server, config *Config) *Server {
+	s := &Server{
+		Server: gsrv,
+		health: health.NewServer(),
+		stop:   make(chan struct{}),
+	}
+	healthpb.RegisterHealthServer(gsrv, s.health)
+	s.updateHealth(config)
+
+	interval := config.HealthCheckInterval
+	if interval == 0 {
+		interval = defaultHealthCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.updateHealth(config)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *Server) updateHealth(config *Config) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if err := config.checkHealth(time.Now()); err != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	for _, service := range []string{"", api.Log_ServiceDesc.ServiceName} {
+		s.health.SetServingStatus(service, status)
+	}
+}
+
+// ログに追記でき、空き容量が足りていて、証明書が有効であれば nil を返す
+func (c *Config) checkHealth(now time.Time) error {
+	if err := c.CommitLog.CheckWritable(); err != nil {
+		return fmt.Errorf("commit log is not writable: %w", err)
+	}
+	if err := c.CommitLog.CheckStorage(); err != nil {
+		return fmt.Errorf("disk guard is tripped: %w", err)
+	}
+	if c.TLSConfig != nil {
+		if err := checkTLS(c.TLSConfig, now); err != nil {
+			return fmt.Errorf("tls material is invalid: %w", err)
+		}
+	}
+	return nil
+}
+
+func checkTLS(config *tls.Config, now time.Time) error {
+	certs := append([]tls.Certificate{}, config.Certificates...)
+	// 読み直しに対応した設定では、現在の証明書をフックから取り出して確認する
+	if config.GetCertificate != nil {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			return err
+		}
+		certs = append(certs, *cert)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificate is loaded")
+	}
+	for _, cert := range certs {
+		leaf := cert.Leaf
+		if leaf == nil {
+			if len(cert.Certificate) == 0 {
+				return fmt.Errorf("certificate is empty")
+			}
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return err
+			}
+		}
+		if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+			return fmt.Errorf("certificate %q is not valid at %s", leaf.Subject.CommonName, now.Format(time.RFC3339))
+		}
+	}
+	if config.ClientAuth >= tls.VerifyClientCertIfGiven && config.ClientCAs == nil && config.GetConfigForClient == nil {
+		return fmt.Errorf("client CA is not loaded")
+	}
+	return nil
+}
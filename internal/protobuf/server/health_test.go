@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealth(t *testing.T) {
+	dir, err := os.MkdirTemp("", "health-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+
+	srv, err := NewGRPCServer(&Config{
+		CommitLog:           clog,
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	requireStatus := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			got, err := srv.Check(ctx, "log.v1.Log")
+			return err == nil && got == want
+		}, time.Second, 10*time.Millisecond)
+	}
+	requireStatus(healthpb.HealthCheckResponse_SERVING)
+
+	// ログを閉じると追記できない
+	require.NoError(t, clog.Close())
+	requireStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	srv.Stop()
+
+	clog, err = log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	srv, err = NewGRPCServer(&Config{CommitLog: clog})
+	require.NoError(t, err)
+	requireStatus(healthpb.HealthCheckResponse_SERVING)
+	srv.GracefulStop()
+	requireStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+func TestHealthWithoutCredentials(t *testing.T) {
+	dir, err := os.MkdirTemp("", "health-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+
+	// 匿名の呼び出しを許可しないサーバでも、ヘルスチェックは認証情報なしで呼び出せる
+	conn := serveInsecure(t, &Config{CommitLog: clog})
+	ctx := context.Background()
+	client := healthpb.NewHealthClient(conn)
+
+	res, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "log.v1.Log"})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+
+	_, err = api.NewLogClient(conn).Consume(ctx, &api.ConsumeRequest{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestCheckTLS(t *testing.T) {
+	now := time.Now()
+	for senario, tc := range map[string]struct {
+		config  func() *tls.Config
+		wantErr bool
+	}{
+		"valid certificate": {
+			config: func() *tls.Config {
+				return &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t, now.Add(time.Hour))}}
+			},
+		},
+		"expired certificate": {
+			config: func() *tls.Config {
+				return &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t, now.Add(-time.Minute))}}
+			},
+			wantErr: true,
+		},
+		"expired certificate from hook": {
+			config: func() *tls.Config {
+				cert := newTestCertificate(t, now.Add(-time.Minute))
+				return &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+					return &cert, nil
+				}}
+			},
+			wantErr: true,
+		},
+		"no certificate": {
+			config:  func() *tls.Config { return &tls.Config{} },
+			wantErr: true,
+		},
+		"client CA is not loaded": {
+			config: func() *tls.Config {
+				return &tls.Config{
+					Certificates: []tls.Certificate{newTestCertificate(t, now.Add(time.Hour))},
+					ClientAuth:   tls.RequireAndVerifyClientCert,
+				}
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(senario, func(t *testing.T) {
+			err := checkTLS(tc.config(), now)
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func newTestCertificate(t *testing.T, notAfter time.Time) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 読み始める位置をオフセットに変換する
+func (s *grpcServer) startOffset(start *api.StartPosition) (uint64, error) {
+	low, high := s.CommitLog.Offsets()
+	switch p := start.Position.(type) {
+	case *api.StartPosition_Earliest:
+		return low, nil
+	case *api.StartPosition_Latest:
+		return high, nil
+	case *api.StartPosition_Timestamp:
+		return s.CommitLog.OffsetForTime(p.Timestamp)
+	case *api.StartPosition_Offset:
+		return p.Offset, nil
+	case *api.StartPosition_FromEnd:
+		if p.FromEnd >= high-low {
+			return low, nil
+		}
+		return high - p.FromEnd, nil
+	}
+	return 0, status.Error(codes.InvalidArgument, "start position is not specified")
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+const reflectionServicePrefix = "/grpc.reflection."
+
+// リフレクションの呼び出しを reflect アクションで認可する。それ以外のRPCはそのまま通す
+func (c *Config) authorizeReflection(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if strings.HasPrefix(info.FullMethod, reflectionServicePrefix) {
+		if err := c.authorizeRequest(
+			ss.Context(), reflectionObject, reflectAction,
+		); err != nil {
+			return err
+		}
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+type actionAuthorizer struct {
+	allowed map[string]bool
+}
+
+func (a actionAuthorizer) Authorize(subject, object, action string) error {
+	if !a.allowed[action] {
+		return status.Error(codes.PermissionDenied, action)
+	}
+	return nil
+}
+
+// TLS なしでサーバを起動して接続する。AllowAnonymous の場合、認可はサブジェクトが空のまま行われる
+func serveInsecure(t *testing.T, config *Config) *grpc.ClientConn {
+	t.Helper()
+	srv, err := NewGRPCServer(config)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestReflection(t *testing.T) {
+	for senario, tc := range map[string]struct {
+		enabled bool
+		allowed bool
+		want    codes.Code
+	}{
+		"list services": {enabled: true, allowed: true, want: codes.OK},
+		"unauthorized":  {enabled: true, allowed: false, want: codes.PermissionDenied},
+		"disabled":      {enabled: false, allowed: true, want: codes.Unimplemented},
+	} {
+		t.Run(senario, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "reflection-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			clog, err := log.NewLog(dir, log.Config{})
+			require.NoError(t, err)
+			defer clog.Close()
+
+			conn := serveInsecure(t, &Config{
+				CommitLog:        clog,
+				AllowAnonymous:   true,
+				Authorizer:       actionAuthorizer{allowed: map[string]bool{reflectAction: tc.allowed}},
+				EnableReflection: tc.enabled,
+			})
+
+			stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+			require.NoError(t, err)
+			err = stream.Send(&rpb.ServerReflectionRequest{
+				MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+			})
+			require.NoError(t, err)
+			res, err := stream.Recv()
+			require.Equal(t, tc.want, status.Code(err))
+			if tc.want != codes.OK {
+				return
+			}
+			var services []string
+			for _, s := range res.GetListServicesResponse().Service {
+				services = append(services, s.Name)
+			}
+			require.Contains(t, services, "log.v1.Log")
+		})
+	}
+}
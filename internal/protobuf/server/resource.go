@@ -0,0 +1,25 @@
+package server
+
+// 認可の対象のリソース。ポリシーでは "topic:orders.*" のように keyMatch のパターンで指定する
+const reflectionObject = "server:reflection"
+
+// トピックを指定しないリクエストは "topic:" を対象とする
+func topicObject(topic string) string {
+	return "topic:" + topic
+}
+
+func groupObject(group string) string {
+	return "group:" + group
+}
+
+func transactionObject(id string) string {
+	return "transaction:" + id
+}
+
+func adminObject(operation string) string {
+	return "admin:" + operation
+}
+
+func aclObject(operation string) string {
+	return "acl:" + operation
+}
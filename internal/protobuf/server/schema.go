@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+
+	api "github.com/Symthy/golang-distributed-service-study/api/v1"
+)
+
+type schemaServer struct {
+	api.UnimplementedSchemaRegistryServer
+	*Config
+}
+
+var _ api.SchemaRegistryServer = (*schemaServer)(nil)
+
+// スキーマはサーバのトピックのものだけ扱う。topic を省略した場合はサーバのトピックとする
+func (s *schemaServer) RegisterSchema(ctx context.Context, req *api.RegisterSchemaRequest) (*api.RegisterSchemaResponse, error) {
+	topic, err := s.authorizeTopic(ctx, req.Schema.GetTopic(), schemaAction)
+	if err != nil {
+		return nil, err
+	}
+	if req.Schema != nil {
+		req.Schema.Topic = topic
+	}
+	version, err := s.SchemaRegistry.Register(req.Schema)
+	if err != nil {
+		return nil, err
+	}
+	return &api.RegisterSchemaResponse{Version: version}, nil
+}
+
+func (s *schemaServer) GetSchema(ctx context.Context, req *api.GetSchemaRequest) (*api.GetSchemaResponse, error) {
+	topic, err := s.authorizeTopic(ctx, req.Topic, consumeAction)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := s.SchemaRegistry.Get(topic, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetSchemaResponse{Schema: schema}, nil
+}
+
+func (s *schemaServer) SetCompatibility(ctx context.Context, req *api.SetCompatibilityRequest) (*api.SetCompatibilityResponse, error) {
+	topic, err := s.authorizeTopic(ctx, req.Topic, schemaAction)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SchemaRegistry.SetCompatibility(topic, req.Compatibility); err != nil {
+		return nil, err
+	}
+	return &api.SetCompatibilityResponse{}, nil
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"time"
 
 	api "github.com/Symthy/golang-distributed-service-study/api/v1"
 
@@ -9,17 +11,70 @@ import (
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 const (
-	objectWildcard = "*"
-	produceAction  = "produce"
-	consumeAction  = "consume"
+	produceAction = "produce"
+	consumeAction = "consume"
+	commitAction  = "commit"
+	schemaAction  = "schema"
+	reflectAction = "reflect"
+	adminAction   = "admin"
+	aclAction     = "acl"
 )
 
+type OffsetStore interface {
+	Commit(group, topic string, offset uint64) error
+	Fetch(group, topic string) (uint64, bool, error)
+}
+
+type SchemaRegistry interface {
+	Register(schema *api.Schema) (uint32, error)
+	Get(topic string, version uint32) (*api.Schema, error)
+	SetCompatibility(topic string, compatibility api.Compatibility) error
+	Validate(topic string, value []byte) error
+}
+
+// リクエストのレコード以外のフィールド分として許容するサイズ
+const messageOverheadBytes = 1024
+
+// MaxRecordBytes を指定しない場合のメッセージサイズの上限。gRPC の受信のデフォルトに合わせる
+const defaultMaxMessageBytes = 4 << 20
+
+// ConsumeRange で件数を指定しなかった場合に返す件数
+const defaultRangeLimit = 100
+
 type Config struct {
-	CommitLog  log.CommitLog
+	CommitLog log.CommitLog
+	// CommitLog が保持するトピック。リクエストのトピックが異なる場合は NotFound、省略した場合はこのトピックとする
+	Topic      string
 	Authorizer Authorizer
+	// 先頭から順に試し、最初に認証できたものを使う (nil: クライアント証明書の CommonName)
+	Authenticators []Authenticator
+	// 認証情報のない呼び出しを名前が空のプリンシパルとして通す
+	AllowAnonymous bool
+	OffsetStore    OffsetStore
+	// 設定されている場合はスキーマレジストリのサービスも登録する
+	SchemaRegistry SchemaRegistry
+	// 1レコードの最大サイズ (0: gRPC のデフォルトのメッセージサイズ上限に従う)。
+	// メッセージサイズの上限は MaxRecordBytes + 1KB で、ProduceBatch は全レコードをこの中に収める必要がある。
+	// Consume・ConsumeRange は上限を超える分を次の応答に回す
+	MaxRecordBytes int
+	// 設定されている場合はヘルスチェックで証明書も確認する
+	TLSConfig *tls.Config
+	// ヘルスチェックの状態を更新する間隔 (0: 5秒)
+	HealthCheckInterval time.Duration
+	// サーバリフレクションを有効にする。呼び出しは reflect アクションで認可する
+	EnableReflection bool
+	// 設定されている場合は Admin サービスも登録する
+	Admin AdminLog
+	// 設定されている場合は Acl サービスも登録する
+	Policies PolicyStore
+	// Admin・Acl サービスの呼び出しの記録先 (nil: 標準エラー出力に JSON で書く)
+	Auditor Auditor
 }
 
 type grpcServer struct {
@@ -36,35 +91,86 @@ func newGrpcServer(config *Config) (*grpcServer, error) {
 	return srv, nil
 }
 
-func NewGRPCServer(config *Config, grpcOpts ...grpc.ServerOption) (*grpc.Server, error) {
+func NewGRPCServer(config *Config, grpcOpts ...grpc.ServerOption) (*Server, error) {
 	// authorizerセット
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_auth.StreamServerInterceptor(config.authenticate),
+	}
+	if config.EnableReflection {
+		streamInterceptors = append(streamInterceptors, config.authorizeReflection)
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		grpc_auth.UnaryServerInterceptor(config.authenticate),
+	}
+	if config.Admin != nil || config.Policies != nil {
+		unaryInterceptors = append(unaryInterceptors, config.audit)
+	}
 	grpcOpts = append(grpcOpts,
 		grpc.StreamInterceptor(
-			grpc_middleware.ChainStreamServer(
-				grpc_auth.StreamServerInterceptor(authenticate),
-			)),
+			grpc_middleware.ChainStreamServer(streamInterceptors...),
+		),
 		grpc.UnaryInterceptor(
-			grpc_middleware.ChainUnaryServer(
-				grpc_auth.UnaryServerInterceptor(authenticate),
-			)),
+			grpc_middleware.ChainUnaryServer(unaryInterceptors...),
+		),
 	)
 
+	if config.MaxRecordBytes > 0 {
+		grpcOpts = append(grpcOpts,
+			grpc.MaxRecvMsgSize(config.MaxRecordBytes+messageOverheadBytes),
+			grpc.MaxSendMsgSize(config.MaxRecordBytes+messageOverheadBytes),
+		)
+	}
+
 	gsrv := grpc.NewServer(grpcOpts...)
 	srv, err := newGrpcServer(config)
 	if err != nil {
 		return nil, err
 	}
 	api.RegisterLogServer(gsrv, srv)
-	return gsrv, nil
+	if config.SchemaRegistry != nil {
+		api.RegisterSchemaRegistryServer(gsrv, &schemaServer{Config: config})
+	}
+	if config.Admin != nil {
+		api.RegisterAdminServer(gsrv, &adminServer{Config: config})
+	}
+	if config.Policies != nil {
+		api.RegisterAclServer(gsrv, &aclServer{Config: config})
+	}
+	if config.EnableReflection {
+		reflection.Register(gsrv)
+	}
+	return newHealthServer(gsrv, config), nil
 }
 
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
-	if err := s.Authorizer.Authorize(
-		subject(ctx), objectWildcard, produceAction,
-	); err != nil {
+	topic, err := s.authorizeTopic(ctx, req.Topic, produceAction)
+	if err != nil {
 		return nil, err
 	}
 
+	if max := s.MaxRecordBytes; max > 0 {
+		if size := api.RecordSize(req.Record); size > uint64(max) {
+			return nil, api.ErrRecordTooLarge{Size: size, Max: uint64(max)}
+		}
+	}
+	if req.Validation == api.ValidationMode_VALIDATION_STRICT {
+		if s.SchemaRegistry == nil {
+			return nil, status.Error(codes.FailedPrecondition, "schema registry is not configured")
+		}
+		if err := s.SchemaRegistry.Validate(topic, req.Record.Value); err != nil {
+			return nil, err
+		}
+	}
+	if req.ProducerId != "" {
+		req.Record.ProducerId = req.ProducerId
+		req.Record.Sequence = req.Sequence
+	}
+	if req.TransactionId != "" {
+		req.Record.TransactionId = req.TransactionId
+	}
+	if err := s.authorizeTransaction(ctx, req.Record.TransactionId); err != nil {
+		return nil, err
+	}
 	offset, err := s.CommitLog.Append(req.Record)
 	if err != nil {
 		return nil, err
@@ -73,17 +179,77 @@ func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api
 }
 
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
-	if err := s.Authorizer.Authorize(
-		subject(ctx), objectWildcard, consumeAction,
-	); err != nil {
+	if _, err := s.authorizeTopic(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+
+	if req.Start != nil {
+		offset, err := s.startOffset(req.Start)
+		if err != nil {
+			return nil, err
+		}
+		req.Offset = offset
+	}
+	if req.MaxRecords > 0 || req.MaxBytes > 0 || req.MaxWaitMs > 0 {
+		res, err := s.fetch(ctx, req, false)
+		if err != nil {
+			return nil, err
+		}
+		return legacyResponse(req, res), nil
+	}
+	record, err := s.read(req.Offset, req.IsolationLevel)
+	if err != nil {
 		return nil, err
 	}
+	return &api.ConsumeResponse{
+		Record:     record,
+		NextOffset: req.Offset + 1,
+	}, nil
+}
 
-	record, err := s.CommitLog.Read(req.Offset)
+func (s *grpcServer) ProduceBatch(ctx context.Context, req *api.ProduceBatchRequest) (*api.ProduceBatchResponse, error) {
+	topic, err := s.authorizeTopic(ctx, req.Topic, produceAction)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range req.Records {
+		if max := s.MaxRecordBytes; max > 0 {
+			if size := api.RecordSize(record); size > uint64(max) {
+				return nil, api.ErrRecordTooLarge{Size: size, Max: uint64(max)}
+			}
+		}
+		if req.Validation == api.ValidationMode_VALIDATION_STRICT {
+			if s.SchemaRegistry == nil {
+				return nil, status.Error(codes.FailedPrecondition, "schema registry is not configured")
+			}
+			if err := s.SchemaRegistry.Validate(topic, record.Value); err != nil {
+				return nil, err
+			}
+		}
+		if req.TransactionId != "" {
+			record.TransactionId = req.TransactionId
+		}
+		if err := s.authorizeTransaction(ctx, record.TransactionId); err != nil {
+			return nil, err
+		}
+	}
+	first, err := s.CommitLog.AppendBatch(req.Records)
 	if err != nil {
 		return nil, err
 	}
-	return &api.ConsumeResponse{Record: record}, nil
+	return &api.ProduceBatchResponse{
+		FirstOffset: first,
+		LastOffset:  first + uint64(len(req.Records)) - 1,
+	}, nil
+}
+
+// トランザクションへの書き込みは、トピックに加えて Begin/Commit/Abort と同じリソースでも認可する
+func (s *grpcServer) authorizeTransaction(ctx context.Context, id string) error {
+	if id == "" {
+		return nil
+	}
+	return s.authorizeRequest(ctx, transactionObject(id), produceAction)
 }
 
 func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
@@ -103,23 +269,171 @@ func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 }
 
 func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	ctx := stream.Context()
+	topic, err := s.authorizeTopic(ctx, req.Topic, consumeAction)
+	if err != nil {
+		return err
+	}
+	committed := false
+	if req.FromCommitted {
+		res, err := s.FetchOffset(ctx, &api.FetchOffsetRequest{Group: req.Group, Topic: topic})
+		if err != nil {
+			return err
+		}
+		if res.Found {
+			req.Offset = res.Offset
+			committed = true
+		}
+	}
+	// コミット済みのオフセットがなければ start から読む
+	if !committed && req.Start != nil {
+		offset, err := s.startOffset(req.Start)
+		if err != nil {
+			return err
+		}
+		req.Offset = offset
+	}
 	for {
-		select {
-		case <-stream.Context().Done():
-			return nil
-		default:
-			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
-			case nil:
-			case api.ErrOffsetOutOfRange:
-				continue
-			default:
-				return err
+		// 追記があるまで待つので、読めるレコードがなくてもループし続けない
+		res, err := s.fetch(ctx, req, true)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
 			}
-			if err = stream.Send(res); err != nil {
+			return err
+		}
+		if len(res.Records) > 0 {
+			if err = stream.Send(legacyResponse(req, res)); err != nil {
 				return err
 			}
-			req.Offset++
 		}
+		req.Offset = res.NextOffset
+	}
+}
+
+func (s *grpcServer) ConsumeRange(ctx context.Context, req *api.ConsumeRangeRequest) (*api.ConsumeRangeResponse, error) {
+	if _, err := s.authorizeTopic(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = defaultRangeLimit
+	}
+	records, next, err := s.CommitLog.ReadRange(req.FromOffset, req.ToOffset, limit, req.IsolationLevel)
+	if err != nil {
+		return nil, err
+	}
+	// メッセージサイズの上限を超える分は次のページに回す
+	if n := s.fitMessage(records); n < len(records) {
+		return &api.ConsumeRangeResponse{Records: records[:n], NextOffset: records[n].Offset}, nil
+	}
+	return &api.ConsumeRangeResponse{Records: records, NextOffset: next}, nil
+}
+
+func (s *grpcServer) GetOffsets(ctx context.Context, req *api.GetOffsetsRequest) (*api.GetOffsetsResponse, error) {
+	if _, err := s.authorizeTopic(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+
+	low, high := s.CommitLog.Offsets()
+	return &api.GetOffsetsResponse{
+		LowWatermark:     low,
+		HighWatermark:    high,
+		LastStableOffset: s.CommitLog.LastStableOffset(),
+	}, nil
+}
+
+func (s *grpcServer) ReadLatestByKey(ctx context.Context, req *api.ReadLatestByKeyRequest) (*api.ReadLatestByKeyResponse, error) {
+	if _, err := s.authorizeTopic(ctx, req.Topic, consumeAction); err != nil {
+		return nil, err
+	}
+
+	record, err := s.CommitLog.ReadLatestByKey(req.Key, req.IsolationLevel)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ReadLatestByKeyResponse{Record: record}, nil
+}
+
+func (s *grpcServer) BeginTransaction(ctx context.Context, req *api.TransactionRequest) (*api.TransactionResponse, error) {
+	if err := s.authorizeRequest(
+		ctx, transactionObject(req.TransactionId), produceAction,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := s.CommitLog.BeginTransaction(req.TransactionId); err != nil {
+		return nil, err
+	}
+	return &api.TransactionResponse{}, nil
+}
+
+func (s *grpcServer) CommitTransaction(ctx context.Context, req *api.TransactionRequest) (*api.TransactionResponse, error) {
+	if err := s.authorizeRequest(
+		ctx, transactionObject(req.TransactionId), produceAction,
+	); err != nil {
+		return nil, err
+	}
+
+	offset, err := s.CommitLog.CommitTransaction(req.TransactionId)
+	if err != nil {
+		return nil, err
+	}
+	return &api.TransactionResponse{Offset: offset}, nil
+}
+
+func (s *grpcServer) AbortTransaction(ctx context.Context, req *api.TransactionRequest) (*api.TransactionResponse, error) {
+	if err := s.authorizeRequest(
+		ctx, transactionObject(req.TransactionId), produceAction,
+	); err != nil {
+		return nil, err
+	}
+
+	offset, err := s.CommitLog.AbortTransaction(req.TransactionId)
+	if err != nil {
+		return nil, err
+	}
+	return &api.TransactionResponse{Offset: offset}, nil
+}
+
+func (s *grpcServer) CommitOffset(ctx context.Context, req *api.CommitOffsetRequest) (*api.CommitOffsetResponse, error) {
+	if err := s.authorizeRequest(
+		ctx, groupObject(req.Group), commitAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.OffsetStore == nil {
+		return nil, status.Error(codes.Unimplemented, "offset store is not configured")
+	}
+	topic, err := s.resolveTopic(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.OffsetStore.Commit(req.Group, topic, req.Offset); err != nil {
+		return nil, err
+	}
+	return &api.CommitOffsetResponse{}, nil
+}
+
+func (s *grpcServer) FetchOffset(ctx context.Context, req *api.FetchOffsetRequest) (*api.FetchOffsetResponse, error) {
+	if err := s.authorizeRequest(
+		ctx, groupObject(req.Group), consumeAction,
+	); err != nil {
+		return nil, err
+	}
+	if s.OffsetStore == nil {
+		return nil, status.Error(codes.Unimplemented, "offset store is not configured")
+	}
+	topic, err := s.resolveTopic(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, found, err := s.OffsetStore.Fetch(req.Group, topic)
+	if err != nil {
+		return nil, err
 	}
+	return &api.FetchOffsetResponse{Offset: offset, Found: found}, nil
 }
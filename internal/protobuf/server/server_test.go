@@ -6,16 +6,20 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	api "github.com/Symthy/golang-distributed-service-study/api/v1"
 	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/auth"
 	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/config"
 	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/log"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/offset"
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/schema"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 func setupTest(t *testing.T, fn func(*Config)) (
@@ -52,7 +56,7 @@ func setupTest(t *testing.T, fn func(*Config)) (
 		return conn, client, clientOptions
 	}
 
-	newServer := func(clog log.CommitLog) *grpc.Server {
+	newServer := func(clog log.CommitLog, offsets OffsetStore, schemas SchemaRegistry) *Server {
 		serverTLSConfig, err := config.SetupTlsConfig(config.TLSConfig{
 			CertFile:      config.ServerCertFile,
 			KeyFile:       config.ServerKeyFile,
@@ -65,8 +69,11 @@ func setupTest(t *testing.T, fn func(*Config)) (
 		authorizer, err := auth.New(config.ACLModelFile, config.ACLPolicyFile)
 		require.NoError(t, err)
 		cfg = &Config{
-			CommitLog:  clog,
-			Authorizer: authorizer,
+			CommitLog:      clog,
+			Authorizer:     authorizer,
+			OffsetStore:    offsets,
+			SchemaRegistry: schemas,
+			TLSConfig:      serverTLSConfig,
 		}
 		if fn != nil {
 			fn(cfg)
@@ -89,7 +96,15 @@ func setupTest(t *testing.T, fn func(*Config)) (
 	require.NoError(t, err)
 	clog, err := log.NewLog(dir, log.Config{})
 	require.NoError(t, err)
-	server := newServer(clog)
+	offsetDir, err := os.MkdirTemp("", "server-test-offsets")
+	require.NoError(t, err)
+	offsets, err := offset.New(offsetDir, log.Config{})
+	require.NoError(t, err)
+	schemaDir, err := os.MkdirTemp("", "server-test-schemas")
+	require.NoError(t, err)
+	schemas, err := schema.NewRegistry(schemaDir)
+	require.NoError(t, err)
+	server := newServer(clog, offsets, schemas)
 
 	go func() {
 		server.Serve(l)
@@ -101,6 +116,9 @@ func setupTest(t *testing.T, fn func(*Config)) (
 		server.Stop()
 		l.Close()
 		clog.Remove()
+		offsets.Close()
+		os.RemoveAll(offsetDir)
+		os.RemoveAll(schemaDir)
 	}
 	return rootClient, nobodyClient, cfg, teardown
 }
@@ -116,9 +134,19 @@ func TestServer(t *testing.T) {
 		"produce/consume stream":          testProduceConsumeStream,
 		"consume past log boundary fails": testConsumePastBoundary,
 		"unauthorized fails":              testUnauthorized,
+		"idempotent produce":              testIdempotentProduce,
+		"read committed transaction":      testReadCommittedTransaction,
+		"commit/fetch offset":             testCommitFetchOffset,
+		"strict schema validation":        testStrictValidation,
+		"fetch batched records":           testFetch,
+		"produce batch/consume range":     testProduceBatchConsumeRange,
+		"start position":                  testStartPosition,
+		"invalid record fails":            testInvalidRecord,
 	} {
 		t.Run(senario, func(t *testing.T) {
-			rootClient, nobodyClient, config, teardown := setupTest(t, nil)
+			rootClient, nobodyClient, config, teardown := setupTest(t, func(c *Config) {
+				c.Topic = "orders"
+			})
 			defer teardown()
 			fn(t, rootClient, nobodyClient, config)
 		})
@@ -217,6 +245,443 @@ func testProduceConsumeStream(t *testing.T, client, _ api.LogClient, config *Con
 	})
 }
 
+func testIdempotentProduce(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	req := &api.ProduceRequest{
+		Record:     &api.Record{Value: []byte("hello world")},
+		ProducerId: "producer",
+		Sequence:   0,
+	}
+	first, err := client.Produce(ctx, req)
+	require.NoError(t, err)
+
+	retried, err := client.Produce(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, first.Offset, retried.Offset)
+
+	req.Sequence = 2
+	_, err = client.Produce(ctx, req)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func testReadCommittedTransaction(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.BeginTransaction(ctx, &api.TransactionRequest{TransactionId: "txn"})
+	require.NoError(t, err)
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record:        &api.Record{Value: []byte("hello world")},
+		TransactionId: "txn",
+	})
+	require.NoError(t, err)
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{
+		Offset:         produce.Offset,
+		IsolationLevel: api.IsolationLevel_READ_COMMITTED,
+	})
+	require.Equal(t, api.ErrOffsetOutOfRange{}.Code(), status.Code(err))
+
+	_, err = client.AbortTransaction(ctx, &api.TransactionRequest{TransactionId: "txn"})
+	require.NoError(t, err)
+	_, err = client.Consume(ctx, &api.ConsumeRequest{
+		Offset:         produce.Offset,
+		IsolationLevel: api.IsolationLevel_READ_COMMITTED,
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testCommitFetchOffset(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second"} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(value)},
+		})
+		require.NoError(t, err)
+	}
+
+	fetch, err := client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "group", Topic: "orders"})
+	require.NoError(t, err)
+	require.False(t, fetch.Found)
+
+	_, err = client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "group", Topic: "orders", Offset: 1})
+	require.NoError(t, err)
+	fetch, err = client.FetchOffset(ctx, &api.FetchOffsetRequest{Group: "group", Topic: "orders"})
+	require.NoError(t, err)
+	require.True(t, fetch.Found)
+	require.Equal(t, uint64(1), fetch.Offset)
+
+	// サーバが保持していないトピックのオフセットはコミットできない
+	_, err = client.CommitOffset(ctx, &api.CommitOffsetRequest{Group: "group", Topic: "other", Offset: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// トピックを省略した場合はサーバのトピックとして読む
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Group:         "group",
+		FromCommitted: true,
+	})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), res.Record.Value)
+}
+
+func testStrictValidation(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	_, err := config.SchemaRegistry.Register(&api.Schema{
+		Topic:      "orders",
+		Type:       api.SchemaType_SCHEMA_TYPE_JSON,
+		Definition: []byte(`{"type":"object","required":["name"]}`),
+	})
+	require.NoError(t, err)
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:     &api.Record{Value: []byte(`{"name":"alice"}`)},
+		Topic:      "orders",
+		Validation: api.ValidationMode_VALIDATION_STRICT,
+	})
+	require.NoError(t, err)
+
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:     &api.Record{Value: []byte(`{}`)},
+		Topic:      "orders",
+		Validation: api.ValidationMode_VALIDATION_STRICT,
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// 検証しない場合はそのまま書き込める
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte(`{}`)},
+		Topic:  "orders",
+	})
+	require.NoError(t, err)
+}
+
+func testFetch(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("message %d", i))},
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("max records", func(t *testing.T) {
+		res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0, MaxRecords: 2})
+		require.NoError(t, err)
+		require.Len(t, res.Records, 2)
+		require.Equal(t, uint64(2), res.NextOffset)
+		require.Equal(t, []byte("message 0"), res.Records[0].Value)
+		// 同じレコードを record にも重ねて送らない
+		require.Nil(t, res.Record)
+	})
+
+	t.Run("single record is sent only in record", func(t *testing.T) {
+		res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 1})
+		require.NoError(t, err)
+		require.Equal(t, []byte("message 1"), res.Record.Value)
+		require.Empty(t, res.Records)
+	})
+
+	t.Run("max bytes returns at least one record", func(t *testing.T) {
+		res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 1, MaxBytes: 1})
+		require.NoError(t, err)
+		require.Len(t, res.Records, 1)
+		require.Equal(t, uint64(2), res.NextOffset)
+	})
+
+	t.Run("max wait returns empty when no records", func(t *testing.T) {
+		start := time.Now()
+		res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 3, MaxRecords: 1, MaxWaitMs: 50})
+		require.NoError(t, err)
+		require.Empty(t, res.Records)
+		require.Equal(t, uint64(3), res.NextOffset)
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("max wait returns when appended", func(t *testing.T) {
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("message 3")}})
+		}()
+		res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 3, MaxRecords: 1, MaxWaitMs: 5000})
+		require.NoError(t, err)
+		require.Len(t, res.Records, 1)
+		require.Equal(t, []byte("message 3"), res.Records[0].Value)
+	})
+}
+
+func TestMessageSize(t *testing.T) {
+	const maxRecordBytes = 256
+	client, _, _, teardown := setupTest(t, func(c *Config) {
+		c.MaxRecordBytes = maxRecordBytes
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	const records = 20
+	value := make([]byte, maxRecordBytes-16)
+
+	t.Run("produce batch must fit in a message", func(t *testing.T) {
+		batch := make([]*api.Record, records)
+		for i := range batch {
+			batch[i] = &api.Record{Value: value}
+		}
+		_, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: batch})
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		for i := 0; i < records; i += 4 {
+			_, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: batch[i : i+4]})
+			require.NoError(t, err)
+		}
+	})
+
+	// 件数の上限に達する前でも、メッセージサイズの上限で次の応答に回す
+	t.Run("consume", func(t *testing.T) {
+		got := 0
+		for got < records {
+			res, err := client.Consume(ctx, &api.ConsumeRequest{Offset: uint64(got), MaxRecords: records})
+			require.NoError(t, err)
+			require.NotEmpty(t, res.Records)
+			require.Less(t, len(res.Records), records)
+			require.LessOrEqual(t, proto.Size(res), maxRecordBytes+messageOverheadBytes)
+			got += len(res.Records)
+			require.Equal(t, uint64(got), res.NextOffset)
+		}
+	})
+
+	t.Run("consume range", func(t *testing.T) {
+		got := 0
+		for got < records {
+			res, err := client.ConsumeRange(ctx, &api.ConsumeRangeRequest{FromOffset: uint64(got)})
+			require.NoError(t, err)
+			require.NotEmpty(t, res.Records)
+			require.Less(t, len(res.Records), records)
+			require.LessOrEqual(t, proto.Size(res), maxRecordBytes+messageOverheadBytes)
+			got += len(res.Records)
+			require.Equal(t, uint64(got), res.NextOffset)
+		}
+	})
+}
+
+func testProduceBatchConsumeRange(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	var records []*api.Record
+	for i := 0; i < 5; i++ {
+		records = append(records, &api.Record{Value: []byte(fmt.Sprintf("message %d", i))})
+	}
+	produce, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: records})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), produce.FirstOffset)
+	require.Equal(t, uint64(4), produce.LastOffset)
+
+	var got []string
+	from := uint64(0)
+	for {
+		res, err := client.ConsumeRange(ctx, &api.ConsumeRangeRequest{FromOffset: from, Limit: 2})
+		require.NoError(t, err)
+		if len(res.Records) == 0 {
+			break
+		}
+		for _, record := range res.Records {
+			got = append(got, string(record.Value))
+		}
+		from = res.NextOffset
+	}
+	require.Len(t, got, 5)
+	require.Equal(t, "message 4", got[4])
+
+	res, err := client.ConsumeRange(ctx, &api.ConsumeRangeRequest{FromOffset: 1, ToOffset: 3})
+	require.NoError(t, err)
+	require.Len(t, res.Records, 2)
+	require.Equal(t, uint64(3), res.NextOffset)
+
+	// クライアントの誤りは InvalidArgument にする
+	for _, records := range [][]*api.Record{
+		nil,
+		{{Value: []byte("commit"), Control: api.ControlType_CONTROL_COMMIT}},
+		{{Value: []byte("blob"), BlobRef: "../../etc/passwd"}},
+		{{Value: []byte("idempotent"), ProducerId: "producer"}},
+	} {
+		_, err = client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: records})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func testInvalidRecord(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	// 制御レコードとブロブの参照はブローカーだけが書く
+	for _, record := range []*api.Record{
+		{Value: []byte("commit"), Control: api.ControlType_CONTROL_COMMIT},
+		{Value: []byte("blob"), BlobRef: "../../etc/passwd"},
+	} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: record})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+func testStartPosition(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{
+			Record: &api.Record{Value: []byte(fmt.Sprintf("message %d", i)), Timestamp: int64(100 * (i + 1))},
+		})
+		require.NoError(t, err)
+	}
+
+	offsets, err := client.GetOffsets(ctx, &api.GetOffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsets.LowWatermark)
+	require.Equal(t, uint64(3), offsets.HighWatermark)
+	require.Equal(t, uint64(3), offsets.LastStableOffset)
+
+	for senario, tc := range map[string]struct {
+		start *api.StartPosition
+		want  string
+	}{
+		"earliest":  {start: &api.StartPosition{Position: &api.StartPosition_Earliest{Earliest: true}}, want: "message 0"},
+		"timestamp": {start: &api.StartPosition{Position: &api.StartPosition_Timestamp{Timestamp: 150}}, want: "message 1"},
+		"offset":    {start: &api.StartPosition{Position: &api.StartPosition_Offset{Offset: 2}}, want: "message 2"},
+		"from end":  {start: &api.StartPosition{Position: &api.StartPosition_FromEnd{FromEnd: 1}}, want: "message 2"},
+		"too far from end": {
+			start: &api.StartPosition{Position: &api.StartPosition_FromEnd{FromEnd: 10}},
+			want:  "message 0",
+		},
+	} {
+		t.Run(senario, func(t *testing.T) {
+			res, err := client.Consume(ctx, &api.ConsumeRequest{Start: tc.start})
+			require.NoError(t, err)
+			require.Equal(t, tc.want, string(res.Record.Value))
+		})
+	}
+
+	t.Run("latest", func(t *testing.T) {
+		res, err := client.Consume(ctx, &api.ConsumeRequest{
+			Start:      &api.StartPosition{Position: &api.StartPosition_Latest{Latest: true}},
+			MaxRecords: 1,
+			MaxWaitMs:  10,
+		})
+		require.NoError(t, err)
+		require.Empty(t, res.Records)
+		require.Equal(t, uint64(3), res.NextOffset)
+	})
+}
+
+func TestTopicScopedAuthorization(t *testing.T) {
+	rootClient, nobodyClient, _, teardown := setupTest(t, func(c *Config) {
+		c.Topic = "public.news"
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := rootClient.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+		Topic:  "public.news",
+	})
+	require.NoError(t, err)
+
+	// nobody はロール経由で public.* からの読み出しのみ許可されている
+	res, err := nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: 0, Topic: "public.news"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), res.Record.Value)
+	res, err = nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), res.Record.Value)
+
+	_, err = nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: 0, Topic: "private"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = nobodyClient.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+		Topic:  "public.news",
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestOtherTopicIsNotReadable(t *testing.T) {
+	rootClient, nobodyClient, _, teardown := setupTest(t, func(c *Config) {
+		c.Topic = "private"
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := rootClient.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("secret")},
+		Topic:  "private",
+	})
+	require.NoError(t, err)
+
+	// public.* だけ読めるプリンシパルは、許可されたトピックを名乗っても private のレコードを読めない
+	for senario, tc := range map[string]struct {
+		topic string
+		want  codes.Code
+	}{
+		"allowed topic": {topic: "public.news", want: codes.NotFound},
+		"server topic":  {topic: "private", want: codes.PermissionDenied},
+		"omitted topic": {topic: "", want: codes.PermissionDenied},
+	} {
+		t.Run(senario, func(t *testing.T) {
+			_, err := nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: 0, Topic: tc.topic})
+			require.Equal(t, tc.want, status.Code(err))
+			_, err = nobodyClient.ConsumeRange(ctx, &api.ConsumeRangeRequest{Topic: tc.topic})
+			require.Equal(t, tc.want, status.Code(err))
+			_, err = nobodyClient.ReadLatestByKey(ctx, &api.ReadLatestByKeyRequest{Key: []byte("k"), Topic: tc.topic})
+			require.Equal(t, tc.want, status.Code(err))
+		})
+	}
+}
+
+func TestSchemaOtherTopic(t *testing.T) {
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	schemas, err := schema.NewRegistry(dir + "-schemas")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir + "-schemas")
+
+	conn := serveInsecure(t, &Config{
+		CommitLog:      clog,
+		SchemaRegistry: schemas,
+		Topic:          "orders",
+		AllowAnonymous: true,
+		// 他のトピックのスキーマの操作が許可されていても、サーバのトピック以外は扱わない
+		Authorizer: objectAuthorizer{allowed: map[string]bool{
+			"topic:orders schema":  true,
+			"topic:orders consume": true,
+			"topic:other schema":   true,
+			"topic:other consume":  true,
+		}},
+	})
+	client := api.NewSchemaRegistryClient(conn)
+	ctx := context.Background()
+
+	_, err = client.RegisterSchema(ctx, &api.RegisterSchemaRequest{Schema: &api.Schema{
+		Topic:      "other",
+		Type:       api.SchemaType_SCHEMA_TYPE_JSON,
+		Definition: []byte(`{"type":"object"}`),
+	}})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.GetSchema(ctx, &api.GetSchemaRequest{Topic: "other"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.SetCompatibility(ctx, &api.SetCompatibilityRequest{Topic: "other"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = schemas.Get("other", 0)
+	require.Error(t, err)
+
+	// topic を省略した場合はサーバのトピックのスキーマを扱う
+	_, err = client.RegisterSchema(ctx, &api.RegisterSchemaRequest{Schema: &api.Schema{
+		Type:       api.SchemaType_SCHEMA_TYPE_JSON,
+		Definition: []byte(`{"type":"object"}`),
+	}})
+	require.NoError(t, err)
+	res, err := client.GetSchema(ctx, &api.GetSchemaRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "orders", res.Schema.Topic)
+}
+
 func testUnauthorized(t *testing.T, _, nobodyClient api.LogClient, config *Config) {
 	ctx := context.Background()
 	produce, err := nobodyClient.Produce(ctx,
@@ -226,7 +691,7 @@ func testUnauthorized(t *testing.T, _, nobodyClient api.LogClient, config *Confi
 			},
 		},
 	)
-	require.NotNil(t, produce)
+	require.Nil(t, produce)
 	require.Equal(t, codes.PermissionDenied, status.Code(err))
 
 	consume, err := nobodyClient.Consume(ctx,
@@ -234,6 +699,61 @@ func testUnauthorized(t *testing.T, _, nobodyClient api.LogClient, config *Confi
 			Offset: 0,
 		},
 	)
-	require.NotNil(t, consume)
+	require.Nil(t, consume)
 	require.Equal(t, codes.PermissionDenied, status.Code(err))
 }
+
+// "object action" の組で許可する
+type objectAuthorizer struct {
+	allowed map[string]bool
+}
+
+func (a objectAuthorizer) Authorize(subject, object, action string) error {
+	if !a.allowed[object+" "+action] {
+		return status.Error(codes.PermissionDenied, object+" "+action)
+	}
+	return nil
+}
+
+func TestTransactionAuthorization(t *testing.T) {
+	dir, err := os.MkdirTemp("", "server-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Close()
+	require.NoError(t, clog.BeginTransaction("tx"))
+
+	conn := serveInsecure(t, &Config{
+		CommitLog:      clog,
+		AllowAnonymous: true,
+		Authorizer: objectAuthorizer{allowed: map[string]bool{
+			"topic: produce": true,
+			"topic: consume": true,
+		}},
+	})
+	client := api.NewLogClient(conn)
+	ctx := context.Background()
+
+	// トピックへの書き込みが許可されていても、トランザクションには書き込めない
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record:        &api.Record{Value: []byte("a")},
+		TransactionId: "tx",
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("a"), TransactionId: "tx"},
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.ProduceBatch(ctx, &api.ProduceBatchRequest{
+		Records: []*api.Record{{Value: []byte("a"), TransactionId: "tx"}},
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// リクエストに transaction_id がない場合、レコードのトランザクションを上書きしない
+	_, err = client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("b")}})
+	require.NoError(t, err)
+	record, err := clog.Read(0)
+	require.NoError(t, err)
+	require.Empty(t, record.TransactionId)
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Symthy/golang-distributed-service-study/internal/protobuf/auth"
+	"google.golang.org/grpc/metadata"
+)
+
+const bearerPrefix = "Bearer "
+
+// 署名の検証に使う共有鍵。キーは JWT ヘッダの kid
+type KeySet map[string][]byte
+
+// JWK Set 形式のファイルから "oct" の鍵を読み込む
+func LoadKeySet(name string) (KeySet, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &jwks); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	keys := KeySet{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "oct" {
+			continue
+		}
+		secret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.K, "="))
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("%s: invalid key %q", name, k.Kid)
+		}
+		keys[k.Kid] = secret
+	}
+	return keys, nil
+}
+
+// authorization メタデータの Bearer トークン (HS256/HS384/HS512 の JWT) を検証し、sub クレームを名前とする。
+// Claims に挙げた文字列のクレームはプリンシパルの属性になる
+type TokenAuthenticator struct {
+	Keys KeySet
+	// 属性にするクレーム。発行者が任意に設定したクレームでポリシーに一致させないよう、挙げたものだけ使う
+	Claims []string
+	// 設定されている場合は iss・aud クレームと一致しなければならない
+	Issuer   string
+	Audience string
+	// 現在時刻 (nil: time.Now)。テスト用
+	Now func() time.Time
+}
+
+func (a TokenAuthenticator) Authenticate(ctx context.Context) (auth.Principal, bool, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return auth.Principal{}, false, nil
+	}
+	if len(values) > 1 || !strings.HasPrefix(values[0], bearerPrefix) {
+		return auth.Principal{}, false, errors.New("authorization must be a single bearer token")
+	}
+	claims, err := a.verify(strings.TrimPrefix(values[0], bearerPrefix))
+	if err != nil {
+		return auth.Principal{}, false, err
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return auth.Principal{}, false, errors.New("token has no sub claim")
+	}
+	p := auth.Principal{Name: sub, Attributes: map[string]string{}}
+	for _, k := range a.Claims {
+		if s, ok := claims[k].(string); ok && k != "sub" {
+			p.Attributes[k] = s
+		}
+	}
+	p.Attributes[authMethodAttribute] = authMethodToken
+	return p, true, nil
+}
+
+func (a TokenAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	newHash, ok := map[string]func() hash.Hash{
+		"HS256": sha256.New,
+		"HS384": sha512.New384,
+		"HS512": sha512.New,
+	}[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm: %q", header.Alg)
+	}
+	key, ok := a.Keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key: %q", header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	mac := hmac.New(newHash, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := a.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// exp は必須。nbf・iss・aud は設定されている場合のみ確認する
+func (a TokenAuthenticator) validateClaims(claims map[string]interface{}) error {
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+	t := now().Unix()
+	exp, ok := claims["exp"].(json.Number)
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if v, err := exp.Int64(); err != nil || t >= v {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(json.Number); ok {
+		if v, err := nbf.Int64(); err != nil || t < v {
+			return errors.New("token is not valid yet")
+		}
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return fmt.Errorf("unexpected issuer: %v", claims["iss"])
+	}
+	if a.Audience != "" && !hasAudience(claims["aud"], a.Audience) {
+		return fmt.Errorf("unexpected audience: %v", claims["aud"])
+	}
+	return nil
+}
+
+// aud は文字列か文字列の配列
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}